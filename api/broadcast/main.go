@@ -0,0 +1,11 @@
+package main
+
+import (
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/broadcast"
+)
+
+func main() {
+	lambda.Start(broadcast.HandleRequest)
+}
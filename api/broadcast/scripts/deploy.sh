@@ -0,0 +1,13 @@
+#!/bin/bash
+echo 'Updating API Lambda-Function...'
+cd `dirname $0`/../
+rm function.zip
+rm bootstrap
+GOARCH=arm64 GOOS=linux CGO_ENABLED=0 go build -o bootstrap main.go
+zip -g function.zip bootstrap
+aws lambda update-function-code \
+	--profile default \
+	--function-name ServerlessChatBroadcastFunction \
+	--zip-file fileb://`pwd`/function.zip \
+	--cli-connect-timeout 6000 \
+	--publish
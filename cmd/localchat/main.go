@@ -0,0 +1,154 @@
+// Command localchat runs the chat on a single machine without AWS.
+// It serves the front page, emulates the API Gateway WebSocket routes and
+// dispatches them to the same handlers the Lambda functions use.
+package main
+
+import (
+	"os"
+	"log"
+	"flag"
+	"time"
+	"context"
+	"strings"
+	"net/http"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/cron"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/broadcast"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/send"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/front"
+)
+
+type route func(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// routes maps the value of "action" in a message body to its handler,
+// like RouteSelectionExpression in template.yml.
+var routes = map[string]route{
+	"send": sendRoute,
+	"sync": sendRoute,
+	"edit": sendRoute,
+	"delete": sendRoute,
+	"react": sendRoute,
+	"thread": sendRoute,
+	"typing": sendRoute,
+	"who": sendRoute,
+	"dm": sendRoute,
+}
+
+// sendRoute is the handler of the routes integrated with OnSendFunction.
+func sendRoute(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	res, err := send.HandleRequest(ctx, request)
+	return events.APIGatewayProxyResponse(res), err
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	cronInterval := flag.Duration("cron", defaultCronInterval(), "interval to run the cron handler, 0 to disable; half of IDLE_TIMEOUT if that is set")
+	flag.Parse()
+
+	setDefaultEnv("STORE_TYPE", "memory")
+	setDefaultEnv("LIMIT_MESSAGE_COUNT", "100")
+	setDefaultEnv("LIMIT_CONNECTION_COUNT", "10")
+	setDefaultEnv("LIMIT_SEND_RATE", "1")
+	setDefaultEnv("LIMIT_SEND_BURST", "10")
+	setDefaultEnv("LIMIT_IP_SEND_RATE", "5")
+	setDefaultEnv("LIMIT_IP_SEND_BURST", "50")
+	setDefaultEnv("WEBSOCKET_URL", "ws://" + *addr + "/ws")
+
+	registry := NewRegistry()
+	chat.SetDefaultConnectionAPI(registry)
+	// The broadcast worker runs in this process and takes its jobs from a channel instead of SQS.
+	queue := chat.NewLocalQueue(1000, 10)
+	chat.SetDefaultBroadcastQueue(queue)
+	go queue.Run(context.Background(), broadcast.HandleJobs)
+	// The connections stored by a previous run, with STORE_TYPE=file, are not in
+	// the registry, so the cron deletes them as gone before they count toward the limits.
+	runCronOnce(time.Now())
+	if *cronInterval > 0 {
+		go runCron(*cronInterval)
+	}
+
+	http.Handle("/ws", &websocketHandler{registry: registry})
+	http.HandleFunc("/", serveFront)
+	log.Printf("listening on http://%s/", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+func setDefaultEnv(key string, value string) {
+	if _, ok := os.LookupEnv(key); !ok {
+		os.Setenv(key, value)
+	}
+}
+
+func serveFront(w http.ResponseWriter, r *http.Request) {
+	routeKey := "GET /"
+	pathParameters := map[string]string{}
+	if name, ok := strings.CutPrefix(r.URL.Path, "/rooms/"); ok && name != "" && !strings.Contains(name, "/") {
+		routeKey = "GET /rooms/{name}"
+		pathParameters["name"] = name
+	} else if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	request := events.APIGatewayV2HTTPRequest{
+		Version:               "2.0",
+		RouteKey:              routeKey,
+		RawPath:               r.URL.Path,
+		RawQueryString:        r.URL.RawQuery,
+		Headers:               firstValues(r.Header),
+		QueryStringParameters: firstValues(r.URL.Query()),
+		PathParameters:        pathParameters,
+	}
+	request.RequestContext.HTTP.Method = r.Method
+	request.RequestContext.HTTP.Path = r.URL.Path
+	request.RequestContext.HTTP.SourceIP = sourceIP(r)
+	res, err := front.HandleRequest(r.Context(), request)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	for k, v := range res.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(res.StatusCode)
+	w.Write([]byte(res.Body))
+}
+
+// defaultCronInterval runs the cron often enough that IDLE_TIMEOUT is kept to within
+// half of it, and once a day to delete lost connections otherwise.
+func defaultCronInterval() time.Duration {
+	idleTimeout, err := time.ParseDuration(os.Getenv("IDLE_TIMEOUT"))
+	if err != nil || idleTimeout <= 0 {
+		return 24 * time.Hour
+	}
+	return idleTimeout / 2
+}
+
+func runCron(interval time.Duration) {
+	for t := range time.Tick(interval) {
+		runCronOnce(t)
+	}
+}
+
+func runCronOnce(t time.Time) {
+	err := cron.HandleRequest(context.Background(), events.CloudWatchEvent{
+		Source:     "aws.events",
+		DetailType: "Scheduled Event",
+		Time:       t,
+	})
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func firstValues(values map[string][]string) map[string]string {
+	m := map[string]string{}
+	for k, v := range values {
+		if len(v) > 0 {
+			m[k] = v[0]
+		}
+	}
+	return m
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"sync/atomic"
+	"context"
+	"github.com/gorilla/websocket"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi/types"
+)
+
+// localConnection is ready once its handshake completed. conn stays nil if it failed.
+// lastActive is when a frame was last received from it, in Unix milliseconds.
+type localConnection struct {
+	mu          sync.Mutex
+	conn        *websocket.Conn
+	ready       chan struct{}
+	once        sync.Once
+	connectedAt time.Time
+	lastActive  atomic.Int64
+}
+
+func newLocalConnection() *localConnection {
+	c := &localConnection{ready: make(chan struct{}), connectedAt: time.Now()}
+	c.lastActive.Store(c.connectedAt.UnixMilli())
+	return c
+}
+
+func (c *localConnection) setReady(conn *websocket.Conn) {
+	c.once.Do(func() {
+		c.conn = conn
+		close(c.ready)
+	})
+}
+
+// wait waits until c is ready. It fails with GoneException if the handshake
+// failed, and with the error of ctx if that ends first.
+func (c *localConnection) wait(ctx context.Context) error {
+	select {
+	case <-c.ready:
+		if c.conn == nil {
+			return errGone()
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Registry keeps the open WebSocket connections and answers PostToConnection
+// the way API Gateway does for the handlers running in this process.
+type Registry struct {
+	mu          sync.Mutex
+	connections map[string]*localConnection
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		connections: map[string]*localConnection{},
+	}
+}
+
+// Reserve registers connectionId before its handshake is answered, so that the
+// posts made as soon as the client sees it wait for Add instead of failing.
+func (r *Registry) Reserve(connectionId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connections[connectionId] = newLocalConnection()
+}
+
+func (r *Registry) Add(connectionId string, conn *websocket.Conn) {
+	r.mu.Lock()
+	c, ok := r.connections[connectionId]
+	if !ok {
+		c = newLocalConnection()
+		r.connections[connectionId] = c
+	}
+	r.mu.Unlock()
+	c.setReady(conn)
+}
+
+func (r *Registry) Remove(connectionId string) {
+	r.mu.Lock()
+	c := r.connections[connectionId]
+	delete(r.connections, connectionId)
+	r.mu.Unlock()
+	if c != nil {
+		c.setReady(nil)
+	}
+}
+
+// Touch records that a frame was received from connectionId.
+func (r *Registry) Touch(connectionId string) {
+	if c := r.get(connectionId); c != nil {
+		c.lastActive.Store(time.Now().UnixMilli())
+	}
+}
+
+func errGone() error {
+	return &types.GoneException{Message: aws.String("Connection is gone")}
+}
+
+func (r *Registry) get(connectionId string) *localConnection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.connections[connectionId]
+}
+
+func (r *Registry) PostToConnection(ctx context.Context, params *apigatewaymanagementapi.PostToConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.PostToConnectionOutput, error) {
+	c := r.get(aws.ToString(params.ConnectionId))
+	if c == nil {
+		return nil, errGone()
+	}
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A write that outlives the context fails like a slow client behind API Gateway.
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, params.Data); err != nil {
+		return nil, &types.GoneException{Message: aws.String(err.Error())}
+	}
+	return &apigatewaymanagementapi.PostToConnectionOutput{}, nil
+}
+
+func (r *Registry) GetConnection(ctx context.Context, params *apigatewaymanagementapi.GetConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.GetConnectionOutput, error) {
+	c := r.get(aws.ToString(params.ConnectionId))
+	if c == nil {
+		return nil, errGone()
+	}
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	lastActive := time.UnixMilli(c.lastActive.Load())
+	return &apigatewaymanagementapi.GetConnectionOutput{
+		ConnectedAt:  aws.Time(c.connectedAt),
+		LastActiveAt: aws.Time(lastActive),
+	}, nil
+}
+
+// DeleteConnection closes the connection. Its handler then runs $disconnect.
+func (r *Registry) DeleteConnection(ctx context.Context, params *apigatewaymanagementapi.DeleteConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.DeleteConnectionOutput, error) {
+	c := r.get(aws.ToString(params.ConnectionId))
+	if c == nil {
+		return nil, errGone()
+	}
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+	_ = c.conn.Close()
+	return &apigatewaymanagementapi.DeleteConnectionOutput{}, nil
+}
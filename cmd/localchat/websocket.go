@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"log"
+	"net"
+	"time"
+	"context"
+	"net/http"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/base64"
+	"github.com/gorilla/websocket"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/connect"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/authorizer"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/disconnect"
+)
+
+type websocketHandler struct {
+	registry *Registry
+}
+
+// gatewayError is the frame API Gateway posts back when a message can not be handled.
+type gatewayError struct {
+	Message      string `json:"message"`
+	ConnectionId string `json:"connectionId"`
+	RequestId    string `json:"requestId"`
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func (h *websocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	connectionId := newId()
+	connectedAt := time.Now()
+
+	// The connection is registered before $connect stores it, so that a broadcast
+	// reaching it in between waits for the handshake instead of finding it gone.
+	h.registry.Reserve(connectionId)
+	// Like API Gateway, the handshake is rejected unless $connect succeeds.
+	request := newRequest(r, connectionId, connectedAt, "$connect", "CONNECT")
+	request.Headers = firstValues(r.Header)
+	request.QueryStringParameters = firstValues(r.URL.Query())
+	if os.Getenv("JWT_KEYS") != "" {
+		authorizerResponse, err := authorizer.HandleRequest(ctx, events.APIGatewayCustomAuthorizerRequestTypeRequest{
+			Type:                  "REQUEST",
+			MethodArn:             "arn:aws:execute-api:local:local:local/local/$connect",
+			Headers:               request.Headers,
+			QueryStringParameters: request.QueryStringParameters,
+		})
+		if err != nil {
+			h.registry.Remove(connectionId)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		request.RequestContext.Authorizer = authorizerResponse.Context
+	}
+	res, err := connect.HandleRequest(ctx, request)
+	if err != nil {
+		log.Print(err)
+		h.registry.Remove(connectionId)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		h.registry.Remove(connectionId)
+		http.Error(w, res.Body, res.StatusCode)
+		return
+	}
+	responseHeader := http.Header{}
+	for k, v := range res.Headers {
+		responseHeader.Set(k, v)
+	}
+	conn, err := upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		log.Print(err)
+		h.registry.Remove(connectionId)
+		h.disconnect(ctx, r, connectionId, connectedAt, websocket.CloseAbnormalClosure)
+		return
+	}
+	h.registry.Add(connectionId, conn)
+	defer conn.Close()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			code := websocket.CloseAbnormalClosure
+			if e, ok := err.(*websocket.CloseError); ok {
+				code = e.Code
+			}
+			h.registry.Remove(connectionId)
+			h.disconnect(ctx, r, connectionId, connectedAt, code)
+			return
+		}
+		h.registry.Touch(connectionId)
+		h.dispatch(ctx, r, connectionId, connectedAt, string(data))
+	}
+}
+
+func (h *websocketHandler) dispatch(ctx context.Context, r *http.Request, connectionId string, connectedAt time.Time, body string) {
+	var selection struct {
+		Action string `json:"action"`
+	}
+	_ = json.Unmarshal([]byte(body), &selection)
+	request := newRequest(r, connectionId, connectedAt, selection.Action, "MESSAGE")
+	request.Body = body
+	handler, ok := routes[selection.Action]
+	if !ok {
+		h.postError(ctx, request, "Forbidden")
+		return
+	}
+	if _, err := handler(ctx, request); err != nil {
+		log.Print(err)
+		h.postError(ctx, request, "Internal server error")
+	}
+}
+
+func (h *websocketHandler) disconnect(ctx context.Context, r *http.Request, connectionId string, connectedAt time.Time, code int) {
+	request := newRequest(r, connectionId, connectedAt, "$disconnect", "DISCONNECT")
+	request.RequestContext.DisconnectStatusCode = int64(code)
+	if _, err := disconnect.HandleRequest(ctx, request); err != nil {
+		log.Print(err)
+	}
+}
+
+func (h *websocketHandler) postError(ctx context.Context, request events.APIGatewayWebsocketProxyRequest, message string) {
+	jsonBytes, _ := json.Marshal(gatewayError{
+		Message:      message,
+		ConnectionId: request.RequestContext.ConnectionID,
+		RequestId:    request.RequestContext.RequestID,
+	})
+	c := h.registry.get(request.RequestContext.ConnectionID)
+	if c == nil || c.wait(ctx) != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.conn.WriteMessage(websocket.TextMessage, jsonBytes)
+}
+
+func newRequest(r *http.Request, connectionId string, connectedAt time.Time, routeKey string, eventType string) events.APIGatewayWebsocketProxyRequest {
+	now := time.Now()
+	var request events.APIGatewayWebsocketProxyRequest
+	request.RequestContext = events.APIGatewayWebsocketProxyRequestContext{
+		Stage:            "local",
+		RequestID:        newId(),
+		APIID:            "local",
+		ConnectedAt:      connectedAt.UnixMilli(),
+		ConnectionID:     connectionId,
+		DomainName:       r.Host,
+		EventType:        eventType,
+		MessageDirection: "IN",
+		RequestTime:      now.Format("02/Jan/2006:15:04:05 -0700"),
+		RequestTimeEpoch: now.UnixMilli(),
+		RouteKey:         routeKey,
+	}
+	request.RequestContext.Identity.SourceIP = sourceIP(r)
+	request.RequestContext.Identity.UserAgent = r.UserAgent()
+	return request
+}
+
+func newId() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,439 @@
+// Command migrate moves the tables, or the file of STORE_TYPE=file, written by
+// older versions to the current layout:
+//   - created and edited held the time as digits like 20060102150405000, in the
+//     time zone of the Lambda functions, which is UTC unless TZ was set. They are
+//     rewritten as Unix milliseconds.
+//   - Connections without a room are put in the default room.
+//   - Messages were keyed by a number shared by all rooms, and had no room or seq
+//     at first. They are copied to a message table keyed by {room}#{slot}, numbered
+//     in each room in the order they were written, with the counter item of each room.
+//
+// Values that were already migrated are left alone, so it can be run again.
+package main
+
+import (
+	"os"
+	"log"
+	"flag"
+	"sort"
+	"time"
+	"errors"
+	"context"
+	"strconv"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+const legacyLayout string = "20060102150405.000"
+
+// legacyMin is the smallest legacy value; Unix milliseconds stay below it until the year 318857.
+const legacyMin int64 = 10000000000000000
+
+type migrator struct {
+	loc     *time.Location
+	limit   int
+	dryRun  bool
+	scanned int
+	updated int
+	copied  int
+	skipped int
+}
+
+// fileMessage is a message in the file written by chat.FileStore. Its Id was a
+// number before each room had its own slots.
+type fileMessage struct {
+	chat.MessageData
+	Id json.RawMessage
+}
+
+// fileData is the layout of the file written by chat.FileStore. Before each room
+// had its own sequence numbers, Seq was the last one of all rooms and Seqs was not set.
+type fileData struct {
+	Seq         int               `json:"seq,omitempty"`
+	Seqs        map[string]int    `json:"seqs"`
+	Connections []chat.Connection `json:"connections"`
+	Messages    []fileMessage     `json:"messages"`
+}
+
+// storeData is the layout chat.FileStore reads.
+type storeData struct {
+	Seqs        map[string]int     `json:"seqs"`
+	Connections []chat.Connection  `json:"connections"`
+	Messages    []chat.MessageData `json:"messages"`
+}
+
+func main() {
+	connectionTable := flag.String("connection-table", os.Getenv("CONNECTION_TABLE_NAME"), "connection table to migrate")
+	messageTable := flag.String("message-table", os.Getenv("MESSAGE_TABLE_NAME"), "message table to copy the messages to")
+	fromMessageTable := flag.String("from-message-table", "", "message table of the older version to copy the messages from")
+	path := flag.String("file", "", "migrate the file of STORE_TYPE=file at this path instead of the tables")
+	tz := flag.String("tz", "UTC", "time zone the old values were written in")
+	limit, _ := strconv.Atoi(os.Getenv("LIMIT_MESSAGE_COUNT"))
+	flag.IntVar(&limit, "limit", limit, "LIMIT_MESSAGE_COUNT of the chat; only the newest messages of each room are kept")
+	dryRun := flag.Bool("dry-run", false, "only report what would be rewritten")
+	flag.Parse()
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m := &migrator{loc: loc, limit: limit, dryRun: *dryRun}
+	ctx := context.Background()
+	if *path != "" {
+		if err = m.migrateFile(*path); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		client := dynamodb.NewFromConfig(chat.GetConfig(ctx))
+		if *connectionTable != "" {
+			if err = m.migrateConnections(ctx, client, *connectionTable); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *fromMessageTable != "" {
+			if *messageTable == "" || *messageTable == *fromMessageTable {
+				log.Fatal("-message-table must be the new table to copy the messages of -from-message-table to")
+			}
+			if err = m.copyMessages(ctx, client, *fromMessageTable, *messageTable); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	verb := "updated"
+	if m.dryRun {
+		verb = "to update"
+	}
+	log.Printf("%d items scanned, %d attributes %s, %d messages copied, %d skipped", m.scanned, m.updated, verb, m.copied, m.skipped)
+}
+
+// legacyTime returns the time of a value made by the old chat.Timestamp.
+func legacyTime(v int64, loc *time.Location)(time.Time, error) {
+	s := strconv.FormatInt(v, 10)
+	return time.ParseInLocation(legacyLayout, s[:len(s) - 3] + "." + s[len(s) - 3:], loc)
+}
+
+// convert returns v as Unix milliseconds and whether it had to be rewritten.
+func (m *migrator) convert(v int64)(int64, bool, error) {
+	if v < legacyMin {
+		return v, false, nil
+	}
+	t, err := legacyTime(v, m.loc)
+	if err != nil {
+		return v, false, err
+	}
+	return chat.Timestamp(t), true, nil
+}
+
+// renumber numbers the messages of each room from 1 in the order they were written,
+// by seq if they had one and by created before that, and sets the ids of their slots.
+// Only the newest limit messages of each room are returned, as the older ones would
+// be overwritten. A reply gets the new seq of its parent, or becomes a message of the
+// room if the parent is not kept. It also returns the last seq of each room.
+func renumber(messageList []chat.MessageData, limit int)([]chat.MessageData, map[string]int) {
+	for i := range messageList {
+		if messageList[i].Room == "" {
+			messageList[i].Room = chat.DefaultRoom
+		}
+	}
+	sort.SliceStable(messageList, func(i, j int) bool {
+		a, b := messageList[i], messageList[j]
+		if a.Room != b.Room {
+			return a.Room < b.Room
+		}
+		if a.Seq != b.Seq {
+			return a.Seq < b.Seq
+		}
+		return a.Created < b.Created
+	})
+	seqs := map[string]int{}
+	for _, item := range messageList {
+		seqs[item.Room]++
+	}
+	var renumbered []chat.MessageData
+	var parents map[int]int
+	seq := 0
+	for i, item := range messageList {
+		if i == 0 || item.Room != messageList[i - 1].Room {
+			parents = map[int]int{}
+			seq = 0
+		}
+		seq++
+		if item.Seq > 0 {
+			parents[item.Seq] = seq
+		}
+		first := seqs[item.Room] - limit + 1
+		if limit > 0 && seq < first {
+			continue
+		}
+		if item.ParentId > 0 {
+			parent := parents[item.ParentId]
+			if limit > 0 && parent < first {
+				parent = 0
+			}
+			item.ParentId = parent
+		}
+		item.Seq = seq
+		item.Id = chat.MessageId(item.Room, seq, limit)
+		renumbered = append(renumbered, item)
+	}
+	return renumbered, seqs
+}
+
+// migrateConnections rewrites created of every connection and puts those without a room in the default room.
+// Each attribute is only set if it still holds the old value, so the connections can be in use.
+func (m *migrator) migrateConnections(ctx context.Context, client *dynamodb.Client, table string) error {
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName:            aws.String(table),
+		ProjectionExpression: aws.String("#k, #c, #r"),
+		ExpressionAttributeNames: map[string]string{
+			"#k": "connectionId",
+			"#c": "created",
+			"#r": "room",
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range page.Items {
+			m.scanned++
+			key := map[string]types.AttributeValue{"connectionId": item["connectionId"]}
+			if err = m.migrateAttribute(ctx, client, table, key, "created", item["created"]); err != nil {
+				return err
+			}
+			if _, ok := item["room"]; !ok {
+				if err = m.setRoom(ctx, client, table, key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (m *migrator) migrateAttribute(ctx context.Context, client *dynamodb.Client, table string, key map[string]types.AttributeValue, name string, value types.AttributeValue) error {
+	n, ok := value.(*types.AttributeValueMemberN)
+	if !ok {
+		return nil
+	}
+	old, err := strconv.ParseInt(n.Value, 10, 64)
+	if err != nil {
+		log.Printf("%s %v: %s is not an integer: %s", table, key, name, n.Value)
+		m.skipped++
+		return nil
+	}
+	v, changed, err := m.convert(old)
+	if err != nil {
+		log.Printf("%s %v: %s: %v", table, key, name, err)
+		m.skipped++
+		return nil
+	} else if !changed {
+		return nil
+	}
+	m.updated++
+	if m.dryRun {
+		return nil
+	}
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET #a = :new"),
+		ConditionExpression: aws.String("#a = :old"),
+		ExpressionAttributeNames: map[string]string{
+			"#a": name,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":new": &types.AttributeValueMemberN{Value: strconv.FormatInt(v, 10)},
+			":old": n,
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		// The item was rewritten or deleted since it was scanned.
+		m.updated--
+		m.skipped++
+		return nil
+	}
+	return err
+}
+
+// setRoom puts a connection in the default room, unless it was closed since it was scanned.
+func (m *migrator) setRoom(ctx context.Context, client *dynamodb.Client, table string, key map[string]types.AttributeValue) error {
+	m.updated++
+	if m.dryRun {
+		return nil
+	}
+	_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 key,
+		UpdateExpression:    aws.String("SET #r = :room"),
+		ConditionExpression: aws.String("attribute_exists(#k) AND attribute_not_exists(#r)"),
+		ExpressionAttributeNames: map[string]string{
+			"#k": "connectionId",
+			"#r": "room",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":room": &types.AttributeValueMemberS{Value: chat.DefaultRoom},
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		m.updated--
+		m.skipped++
+		return nil
+	}
+	return err
+}
+
+// copyMessages copies the messages of the table from to the table to, renumbered
+// in each room, and writes the counter item of each room. Items that are already
+// in the table to are left alone, so it never overwrites messages sent since.
+func (m *migrator) copyMessages(ctx context.Context, client *dynamodb.Client, from string, to string) error {
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName: aws.String(from),
+	})
+	var messageList []chat.MessageData
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, i := range page.Items {
+			m.scanned++
+			// The item with id 0 held the last seq of all rooms.
+			if n, ok := i["id"].(*types.AttributeValueMemberN); ok && n.Value == "0" {
+				continue
+			}
+			delete(i, "id")
+			var item chat.MessageData
+			if err = attributevalue.UnmarshalMap(i, &item); err != nil {
+				log.Printf("%s: %v", from, err)
+				m.skipped++
+				continue
+			}
+			if err = m.migrateField(&item.Created); err != nil {
+				return err
+			}
+			if err = m.migrateField(&item.Edited); err != nil {
+				return err
+			}
+			messageList = append(messageList, item)
+		}
+	}
+	messageList, seqs := renumber(messageList, m.limit)
+	for _, item := range messageList {
+		av, err := attributevalue.MarshalMap(item)
+		if err != nil {
+			return err
+		}
+		if err = m.putNew(ctx, client, to, av); err != nil {
+			return err
+		}
+	}
+	for room, seq := range seqs {
+		av, err := attributevalue.MarshalMap(struct {
+			Id  string `dynamodbav:"id"`
+			Seq int    `dynamodbav:"seq"`
+		}{chat.CounterId(room), seq})
+		if err != nil {
+			return err
+		}
+		if err = m.putNew(ctx, client, to, av); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putNew puts av in table unless an item with its id is there.
+func (m *migrator) putNew(ctx context.Context, client *dynamodb.Client, table string, av map[string]types.AttributeValue) error {
+	m.copied++
+	if m.dryRun {
+		return nil
+	}
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(#i)"),
+		ExpressionAttributeNames: map[string]string{
+			"#i": "id",
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		m.copied--
+		m.skipped++
+		return nil
+	}
+	return err
+}
+
+// migrateFile rewrites the file at path. Stop localchat before, as it keeps the contents in memory.
+func (m *migrator) migrateFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var d fileData
+	if err = json.Unmarshal(b, &d); err != nil {
+		return err
+	}
+	out := storeData{Seqs: d.Seqs, Connections: d.Connections}
+	for i := range out.Connections {
+		m.scanned++
+		if err = m.migrateField(&out.Connections[i].Created); err != nil {
+			return err
+		}
+		if out.Connections[i].Room == "" {
+			out.Connections[i].Room = chat.DefaultRoom
+			m.updated++
+		}
+	}
+	for _, fm := range d.Messages {
+		m.scanned++
+		item := fm.MessageData
+		if err = m.migrateField(&item.Created); err != nil {
+			return err
+		}
+		if err = m.migrateField(&item.Edited); err != nil {
+			return err
+		}
+		if d.Seqs != nil {
+			if err = json.Unmarshal(fm.Id, &item.Id); err != nil {
+				return err
+			}
+		}
+		out.Messages = append(out.Messages, item)
+	}
+	if d.Seqs == nil {
+		out.Messages, out.Seqs = renumber(out.Messages, m.limit)
+		m.copied += len(out.Messages)
+	}
+	if m.dryRun || (m.updated == 0 && m.copied == 0) {
+		return nil
+	}
+	b, err = json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+func (m *migrator) migrateField(v *int64) error {
+	n, changed, err := m.convert(*v)
+	if err != nil {
+		return err
+	}
+	if changed {
+		*v = n
+		m.updated++
+	}
+	return nil
+}
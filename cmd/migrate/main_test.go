@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"time"
+	"context"
+	"testing"
+	"path/filepath"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// legacyFile is a file written before times were Unix milliseconds and rooms had
+// their own sequence numbers: one seq for all rooms, numeric ids, and a connection
+// and a message without a room.
+const legacyFile string = `{
+  "seq": 5,
+  "connections": [
+    {"ConnectionId": "c1", "Created": 20240102030405006, "Color": "abc"}
+  ],
+  "messages": [
+    {"Id": 1, "Seq": 1, "Data": "first", "Created": 20240102030405006},
+    {"Id": 2, "Seq": 2, "Room": "other", "Data": "elsewhere", "Created": 20240102030406000},
+    {"Id": 3, "Seq": 3, "Room": "default", "Data": "reply", "Created": 20240102030407000, "ParentId": 1},
+    {"Id": 4, "Seq": 5, "Room": "default", "Data": "last", "Created": 20240102030409000, "Edited": 20240102030410000}
+  ]
+}`
+
+func TestMigrateFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "chat.json")
+	if err := os.WriteFile(path, []byte(legacyFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &migrator{loc: time.UTC}
+	if err := m.migrateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if m.copied != 4 || m.updated != 7 {
+		t.Errorf("%d messages copied and %d attributes updated, want 4 and 7", m.copied, m.updated)
+	}
+
+	store, err := chat.NewFileStore(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection, err := store.GetConnection(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := chat.Timestamp(time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC))
+	if connection.Room != chat.DefaultRoom || connection.Created != created {
+		t.Errorf("connection = %+v, want room %q and created %d", connection, chat.DefaultRoom, created)
+	}
+
+	messageList, err := store.ListMessages(ctx, chat.MessageQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messageList) != 3 {
+		t.Fatalf("default room has %d messages, want 3", len(messageList))
+	}
+	for i, want := range []string{"first", "reply", "last"} {
+		if item := messageList[i]; item.Data != want || item.Seq != i + 1 {
+			t.Errorf("message %d = seq %d %q, want seq %d %q", i, item.Seq, item.Data, i + 1, want)
+		}
+	}
+	if messageList[0].Created != created {
+		t.Errorf("created = %d, want %d", messageList[0].Created, created)
+	}
+	if messageList[1].ParentId != 1 {
+		t.Errorf("reply has parent %d, want 1", messageList[1].ParentId)
+	}
+	if edited := chat.Timestamp(time.Date(2024, 1, 2, 3, 4, 10, 0, time.UTC)); messageList[2].Edited != edited {
+		t.Errorf("edited = %d, want %d", messageList[2].Edited, edited)
+	}
+	if item, err := store.GetMessage(ctx, "other", 1); err != nil || item.Data != "elsewhere" {
+		t.Errorf("GetMessage(other, 1) = %+v, %v", item, err)
+	}
+
+	// New messages follow the migrated ones instead of overwriting them.
+	saved, err := store.SaveMessage(ctx, chat.MessageData{Data: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Seq != 4 {
+		t.Errorf("new message has seq %d, want 4", saved.Seq)
+	}
+
+	// A second run finds nothing to migrate.
+	m = &migrator{loc: time.UTC}
+	if err = m.migrateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if m.copied != 0 || m.updated != 0 {
+		t.Errorf("second run copied %d messages and updated %d attributes, want none", m.copied, m.updated)
+	}
+}
+
+func TestRenumberKeepsNewestOfEachRoom(t *testing.T) {
+	messageList, seqs := renumber([]chat.MessageData{
+		{Data: "a", Created: 1},
+		{Data: "b", Created: 2},
+		{Data: "c", Created: 3},
+		{Room: "other", Data: "d", Created: 4},
+	}, 2)
+	if seqs[chat.DefaultRoom] != 3 || seqs["other"] != 1 {
+		t.Errorf("seqs = %v, want 3 in the default room and 1 in other", seqs)
+	}
+	var ids []string
+	for _, item := range messageList {
+		ids = append(ids, item.Id + " " + item.Data)
+	}
+	want := []string{"default#2 b", "default#1 c", "other#1 d"}
+	if len(ids) != len(want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("ids = %v, want %v", ids, want)
+			break
+		}
+	}
+}
@@ -11,4 +11,6 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi latest
 	github.com/aws/aws-sdk-go-v2/service/dynamodb latest
 	github.com/aws/aws-sdk-go-v2/service/s3 latest
+	github.com/aws/aws-sdk-go-v2/service/sqs latest
+	github.com/gorilla/websocket latest
 )
@@ -0,0 +1,227 @@
+// Package auth verifies the JSON Web Tokens presented when connecting.
+package auth
+
+import (
+	"time"
+	"bytes"
+	"errors"
+	"crypto"
+	"strings"
+	"crypto/rsa"
+	"crypto/hmac"
+	"crypto/x509"
+	"crypto/sha256"
+	"encoding/pem"
+	"encoding/json"
+	"encoding/base64"
+)
+
+// Key is a verification key. Key holds the shared secret for HS256
+// or the PEM encoded public key for RS256.
+type Key struct {
+	Alg       string `json:"alg"`
+	Key       string `json:"key"`
+	publicKey *rsa.PublicKey
+}
+
+// KeySet maps a key id ("kid" in the token header) to its key.
+type KeySet map[string]*Key
+
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Name      string   `json:"name"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// Verifier checks the signature with Keys and, when set, the issuer and audience.
+type Verifier struct {
+	Keys     KeySet
+	Issuer   string
+	Audience string
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// audience accepts both forms of "aud": a string or an array of strings.
+type audience []string
+
+var ErrInvalidToken = errors.New("invalid token")
+var ErrExpiredToken = errors.New("token is expired")
+
+// ParseKeySet reads a key set written as JSON, for example
+// {"main": {"alg": "HS256", "key": "secret"}}.
+func ParseKeySet(s string)(KeySet, error) {
+	var keySet KeySet
+	if err := json.Unmarshal([]byte(s), &keySet); err != nil {
+		return nil, err
+	}
+	for kid, key := range keySet {
+		if key == nil || key.Key == "" {
+			return nil, errors.New("key " + kid + " is empty")
+		}
+		switch key.Alg {
+		case "HS256":
+		case "RS256":
+			block, _ := pem.Decode([]byte(key.Key))
+			if block == nil {
+				return nil, errors.New("key " + kid + " is not PEM encoded")
+			}
+			pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			publicKey, ok := pub.(*rsa.PublicKey)
+			if !ok {
+				return nil, errors.New("key " + kid + " is not an RSA public key")
+			}
+			key.publicKey = publicKey
+		default:
+			return nil, errors.New("key " + kid + " has unsupported alg " + key.Alg)
+		}
+	}
+	return keySet, nil
+}
+
+// Verify returns the claims of token if it is signed by one of the keys and valid at now.
+func (v *Verifier) Verify(token string, now time.Time)(Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidToken
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return claims, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	var keyList []*Key
+	if h.Kid != "" {
+		if key, ok := v.Keys[h.Kid]; ok {
+			keyList = append(keyList, key)
+		}
+	} else {
+		for _, key := range v.Keys {
+			keyList = append(keyList, key)
+		}
+	}
+	verified := false
+	for _, key := range keyList {
+		// The algorithm of the key decides, so a token can not downgrade it.
+		if key.Alg == h.Alg && key.verify(signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return claims, ErrInvalidToken
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+	if claims.ExpiresAt > 0 && now.Unix() >= claims.ExpiresAt {
+		return claims, ErrExpiredToken
+	}
+	if claims.NotBefore > 0 && now.Unix() < claims.NotBefore {
+		return claims, ErrInvalidToken
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return claims, ErrInvalidToken
+	}
+	if v.Audience != "" && !claims.Audience.contains(v.Audience) {
+		return claims, ErrInvalidToken
+	}
+	if claims.Subject == "" {
+		return claims, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (k *Key) verify(signed []byte, signature []byte) bool {
+	switch k.Alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, []byte(k.Key))
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case "RS256":
+		if k.publicKey == nil {
+			return false
+		}
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k.publicKey, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(b, []byte("[")) {
+		var list []string
+		if err := json.Unmarshal(b, &list); err != nil {
+			return err
+		}
+		*a = list
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*a = audience{s}
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, i := range a {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Token returns the token sent by the client, either as the "token" query
+// parameter or as one of the values of the Sec-WebSocket-Protocol header.
+func Token(queryStringParameters map[string]string, headers map[string]string) string {
+	if token := queryStringParameters["token"]; token != "" {
+		return token
+	}
+	for _, protocol := range Protocols(headers) {
+		if strings.Count(protocol, ".") == 2 {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// Protocols returns the values of the Sec-WebSocket-Protocol header.
+func Protocols(headers map[string]string) []string {
+	var protocols []string
+	for k, v := range headers {
+		if !strings.EqualFold(k, "Sec-WebSocket-Protocol") {
+			continue
+		}
+		for _, protocol := range strings.Split(v, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"time"
+	"errors"
+	"crypto"
+	"testing"
+	"crypto/rsa"
+	"crypto/rand"
+	"crypto/hmac"
+	"crypto/x509"
+	"crypto/sha256"
+	"encoding/pem"
+	"encoding/json"
+	"encoding/base64"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func segment(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hs256 returns a token with header h and claims signed with secret.
+func hs256(t *testing.T, h header, claims map[string]interface{}, secret string) string {
+	signed := segment(t, h) + "." + segment(t, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// rs256 returns a token with header h and claims signed with privateKey.
+func rs256(t *testing.T, h header, claims map[string]interface{}, privateKey *rsa.PrivateKey) string {
+	signed := segment(t, h) + "." + segment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// testKeys returns a key set with the HS256 key "hs" and the RS256 key "rs",
+// the private key of "rs" and its public key in PEM.
+func testKeys(t *testing.T)(KeySet, *rsa.PrivateKey, string) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPem := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	b, err := json.Marshal(map[string]Key{
+		"hs": {Alg: "HS256", Key: "secret"},
+		"rs": {Alg: "RS256", Key: publicPem},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySet, err := ParseKeySet(string(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keySet, privateKey, publicPem
+}
+
+func TestVerify(t *testing.T) {
+	keySet, privateKey, publicPem := testKeys(t)
+	v := &Verifier{Keys: keySet}
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "name": "Alice", "exp": now.Unix() + 60}
+		for k, val := range extra {
+			c[k] = val
+		}
+		return c
+	}
+	tests := []struct {
+		what  string
+		token string
+		err   error
+	}{
+		{"HS256", hs256(t, header{Alg: "HS256", Kid: "hs"}, claims(nil), "secret"), nil},
+		{"HS256 without kid", hs256(t, header{Alg: "HS256"}, claims(nil), "secret"), nil},
+		{"RS256", rs256(t, header{Alg: "RS256", Kid: "rs"}, claims(nil), privateKey), nil},
+		{"wrong secret", hs256(t, header{Alg: "HS256", Kid: "hs"}, claims(nil), "guess"), ErrInvalidToken},
+		{"unknown kid", hs256(t, header{Alg: "HS256", Kid: "other"}, claims(nil), "secret"), ErrInvalidToken},
+		{"kid of another key", rs256(t, header{Alg: "RS256", Kid: "hs"}, claims(nil), privateKey), ErrInvalidToken},
+		// Signed with the public key as an HMAC secret, the classic downgrade of RS256.
+		{"alg of another key", hs256(t, header{Alg: "HS256", Kid: "rs"}, claims(nil), publicPem), ErrInvalidToken},
+		{"alg none", segment(t, header{Alg: "none"}) + "." + segment(t, claims(nil)) + ".", ErrInvalidToken},
+		{"expired", hs256(t, header{Alg: "HS256"}, claims(map[string]interface{}{"exp": now.Unix()}), "secret"), ErrExpiredToken},
+		{"not yet valid", hs256(t, header{Alg: "HS256"}, claims(map[string]interface{}{"nbf": now.Unix() + 10}), "secret"), ErrInvalidToken},
+		{"valid from now", hs256(t, header{Alg: "HS256"}, claims(map[string]interface{}{"nbf": now.Unix()}), "secret"), nil},
+		{"no subject", hs256(t, header{Alg: "HS256"}, claims(map[string]interface{}{"sub": ""}), "secret"), ErrInvalidToken},
+		{"two segments", "a.b", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		c, err := v.Verify(tt.token, now)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.what, err, tt.err)
+		}
+		if err == nil && (c.Subject != "alice" || c.Name != "Alice") {
+			t.Errorf("%s: claims %+v", tt.what, c)
+		}
+	}
+}
+
+func TestVerifyAudience(t *testing.T) {
+	keySet, _, _ := testKeys(t)
+	v := &Verifier{Keys: keySet, Issuer: "issuer", Audience: "chat"}
+	tests := []struct {
+		what   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{"string aud", map[string]interface{}{"aud": "chat"}, nil},
+		{"list aud", map[string]interface{}{"aud": []string{"other", "chat"}}, nil},
+		{"other string aud", map[string]interface{}{"aud": "other"}, ErrInvalidToken},
+		{"other list aud", map[string]interface{}{"aud": []string{"other"}}, ErrInvalidToken},
+		{"no aud", map[string]interface{}{}, ErrInvalidToken},
+		{"other issuer", map[string]interface{}{"aud": "chat", "iss": "mallory"}, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		claims := map[string]interface{}{"sub": "alice", "iss": "issuer"}
+		for k, val := range tt.claims {
+			claims[k] = val
+		}
+		if _, err := v.Verify(hs256(t, header{Alg: "HS256"}, claims, "secret"), now); !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, want %v", tt.what, err, tt.err)
+		}
+	}
+}
+
+func TestParseKeySetErrors(t *testing.T) {
+	for _, s := range []string{
+		`{"a": null}`,
+		`{"a": {"alg": "HS256"}}`,
+		`{"a": {"alg": "none", "key": "secret"}}`,
+		`{"a": {"alg": "RS256", "key": "not pem"}}`,
+		`not json`,
+	} {
+		if _, err := ParseKeySet(s); err == nil {
+			t.Errorf("ParseKeySet(%s) accepted it", s)
+		}
+	}
+}
+
+func TestToken(t *testing.T) {
+	tests := []struct {
+		what    string
+		query   map[string]string
+		headers map[string]string
+		want    string
+	}{
+		{"query", map[string]string{"token": "a.b.c"}, nil, "a.b.c"},
+		{"protocol", nil, map[string]string{"Sec-WebSocket-Protocol": "chat, a.b.c"}, "a.b.c"},
+		{"lower case header", nil, map[string]string{"sec-websocket-protocol": "a.b.c,chat"}, "a.b.c"},
+		{"query first", map[string]string{"token": "q.q.q"}, map[string]string{"Sec-WebSocket-Protocol": "a.b.c"}, "q.q.q"},
+		{"no token", nil, map[string]string{"Sec-WebSocket-Protocol": "chat"}, ""},
+	}
+	for _, tt := range tests {
+		if got := Token(tt.query, tt.headers); got != tt.want {
+			t.Errorf("%s: Token = %q, want %q", tt.what, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package authorizer
+
+import (
+	"os"
+	"log"
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/auth"
+)
+
+var verifier *auth.Verifier
+var verifierOnce sync.Once
+
+// HandleRequest allows $connect for a valid token and passes the user to the
+// connect handler through the authorizer context.
+func HandleRequest(ctx context.Context, request events.APIGatewayCustomAuthorizerRequestTypeRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+	verifierOnce.Do(initVerifier)
+	token := auth.Token(request.QueryStringParameters, request.Headers)
+	if verifier == nil || token == "" {
+		return events.APIGatewayCustomAuthorizerResponse{}, errors.New("Unauthorized")
+	}
+	claims, err := verifier.Verify(token, time.Now())
+	if err != nil {
+		log.Print(err)
+		return events.APIGatewayCustomAuthorizerResponse{}, errors.New("Unauthorized")
+	}
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID: claims.Subject,
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version: "2012-10-17",
+			Statement: []events.IAMPolicyStatement{
+				{
+					Action:   []string{"execute-api:Invoke"},
+					Effect:   "Allow",
+					Resource: []string{request.MethodArn},
+				},
+			},
+		},
+		Context: map[string]interface{}{
+			"userId": claims.Subject,
+			"name":   claims.Name,
+		},
+	}, nil
+}
+
+func initVerifier() {
+	keySet, err := auth.ParseKeySet(os.Getenv("JWT_KEYS"))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	verifier = &auth.Verifier{
+		Keys:     keySet,
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	}
+}
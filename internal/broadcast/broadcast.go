@@ -0,0 +1,65 @@
+package broadcast
+
+import (
+	"log"
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// HandleRequest posts the broadcast jobs of a batch from the SQS FIFO queue. The jobs
+// that failed are reported, so that only they are received again. Once a job of a room
+// failed, the later jobs of the room are reported without being posted, to keep their order.
+func HandleRequest(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	var res events.SQSEventResponse
+	cfg := chat.GetConfig(ctx)
+	failedGroups := map[string]bool{}
+	for _, record := range event.Records {
+		group := record.Attributes["MessageGroupId"]
+		if failedGroups[group] {
+			res.BatchItemFailures = append(res.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+			continue
+		}
+		var job chat.BroadcastJob
+		if err := json.Unmarshal([]byte(record.Body), &job); err != nil {
+			// A job that can not be read never will be.
+			log.Print(err)
+			continue
+		}
+		if err := Post(ctx, cfg, job); err != nil {
+			failedGroups[group] = true
+			res.BatchItemFailures = append(res.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+		}
+	}
+	return res, nil
+}
+
+// HandleJobs posts a batch of jobs from a chat.LocalQueue.
+func HandleJobs(ctx context.Context, jobs []chat.BroadcastJob) error {
+	var err error
+	cfg := chat.GetConfig(ctx)
+	for _, job := range jobs {
+		if e := Post(ctx, cfg, job); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Post posts the frame of job to the room as it is now.
+func Post(ctx context.Context, cfg aws.Config, job chat.BroadcastJob) error {
+	apigatewayClient := chat.DefaultConnectionAPI(cfg, job.RequestContext())
+	err := chat.Broadcast(ctx, chat.DefaultStore(ctx), apigatewayClient, job.Room, job.Data, job.Skip)
+	if err != nil {
+		log.Print(err)
+	}
+	return err
+}
@@ -0,0 +1,121 @@
+package broadcast
+
+import (
+	"time"
+	"errors"
+	"slices"
+	"context"
+	"testing"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat/chattest"
+)
+
+// brokenStore fails to list the connections of the room "broken" the first time.
+type brokenStore struct {
+	chat.Store
+	failed bool
+}
+
+func (s *brokenStore) ListRoomConnections(ctx context.Context, room string)([]chat.Connection, error) {
+	if room == "broken" && !s.failed {
+		s.failed = true
+		return nil, errors.New("store is broken")
+	}
+	return s.Store.ListRoomConnections(ctx, room)
+}
+
+func setup(t *testing.T) *chattest.API {
+	t.Setenv("REGION", "us-east-1")
+	ctx := context.Background()
+	store := chat.NewMemoryStore(0)
+	for _, c := range []chat.Connection{
+		{ConnectionId: "c1", Room: "big"},
+		{ConnectionId: "c2", Room: "big"},
+		{ConnectionId: "c3", Room: "other"},
+		{ConnectionId: "c4", Room: "broken"},
+	} {
+		if err := store.PutConnection(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &chattest.API{}
+	chat.SetDefaultStore(&brokenStore{Store: store})
+	chat.SetDefaultConnectionAPI(api)
+	t.Cleanup(func() {
+		chat.SetDefaultStore(nil)
+		chat.SetDefaultConnectionAPI(nil)
+	})
+	return api
+}
+
+func TestLocalQueue(t *testing.T) {
+	api := setup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	queue := chat.NewLocalQueue(32, 4)
+	go queue.Run(ctx, HandleJobs)
+
+	var want []string
+	for _, data := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"} {
+		if err := queue.Enqueue(ctx, chat.BroadcastJob{Room: "big", Data: []byte(data), Skip: "c2"}); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, data)
+	}
+	if err := queue.Enqueue(ctx, chat.BroadcastJob{Room: "big", Data: []byte("11")}); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "11")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(api.Posts("c1")) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := api.Posts("c1"); !slices.Equal(got, want) {
+		t.Errorf("c1 got %v, want %v in order", got, want)
+	}
+	if got := api.Posts("c2"); !slices.Equal(got, []string{"11"}) {
+		t.Errorf("c2 got %v, want only the job that did not skip it", got)
+	}
+	if got := api.Posts("c3"); len(got) > 0 {
+		t.Errorf("c3 in another room got %v", got)
+	}
+}
+
+func TestHandleRequestKeepsOrderOfFailedRoom(t *testing.T) {
+	api := setup(t)
+	record := func(id string, room string, data string) events.SQSMessage {
+		b, _ := json.Marshal(chat.BroadcastJob{Room: room, Data: []byte(data)})
+		return events.SQSMessage{
+			MessageId:  id,
+			Body:       string(b),
+			Attributes: map[string]string{"MessageGroupId": room},
+		}
+	}
+	res, err := HandleRequest(context.Background(), events.SQSEvent{Records: []events.SQSMessage{
+		record("m1", "broken", "1"),
+		record("m2", "big", "2"),
+		record("m3", "broken", "3"),
+		record("m4", "big", "4"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed []string
+	for _, f := range res.BatchItemFailures {
+		failed = append(failed, f.ItemIdentifier)
+	}
+	if !slices.Equal(failed, []string{"m1", "m3"}) {
+		t.Errorf("failures %v, want m1 and m3", failed)
+	}
+	if got := api.Posts("c1"); !slices.Equal(got, []string{"2", "4"}) {
+		t.Errorf("c1 got %v, want 2 and 4", got)
+	}
+	// The store works again for m3, but it must wait for m1 to be received again.
+	if got := api.Posts("c4"); len(got) > 0 {
+		t.Errorf("c4 got %v before the failed job", got)
+	}
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"sync"
+	"context"
+	"net/url"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+)
+
+// ConnectionAPI is the part of the API Gateway Management API used to reach clients.
+type ConnectionAPI interface {
+	PostToConnection(ctx context.Context, params *apigatewaymanagementapi.PostToConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.PostToConnectionOutput, error)
+	GetConnection(ctx context.Context, params *apigatewaymanagementapi.GetConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.GetConnectionOutput, error)
+	DeleteConnection(ctx context.Context, params *apigatewaymanagementapi.DeleteConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.DeleteConnectionOutput, error)
+}
+
+var defaultConnectionAPI ConnectionAPI
+var defaultConnectionAPIMu sync.Mutex
+
+// DefaultConnectionAPI returns the ConnectionAPI shared by the handlers in this process.
+// Unless SetDefaultConnectionAPI was called, it is an API Gateway client for the
+// endpoint the request came from.
+func DefaultConnectionAPI(cfg aws.Config, requestContext events.APIGatewayWebsocketProxyRequestContext) ConnectionAPI {
+	defaultConnectionAPIMu.Lock()
+	defer defaultConnectionAPIMu.Unlock()
+	if defaultConnectionAPI == nil {
+		var endpoint url.URL
+		endpoint.Scheme = "https"
+		endpoint.Path = requestContext.Stage
+		endpoint.Host = requestContext.DomainName
+		endpointResolver := apigatewaymanagementapi.EndpointResolverFromURL(endpoint.String())
+		defaultConnectionAPI = apigatewaymanagementapi.NewFromConfig(cfg, apigatewaymanagementapi.WithEndpointResolver(endpointResolver))
+	}
+	return defaultConnectionAPI
+}
+
+func SetDefaultConnectionAPI(api ConnectionAPI) {
+	defaultConnectionAPIMu.Lock()
+	defer defaultConnectionAPIMu.Unlock()
+	defaultConnectionAPI = api
+}
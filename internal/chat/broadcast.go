@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"os"
+	"log"
+	"context"
+	"strconv"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Events of system frames.
+const (
+	EventJoined string = "joined"
+	EventLeft   string = "left"
+	EventSynced string = "synced"
+)
+
+// Broadcast posts data to the connections in room except skip,
+// and deletes the connections that are gone.
+func Broadcast(ctx context.Context, store ConnectionStore, apigatewayClient ConnectionAPI, room string, data []byte, skip string) error {
+	connectionList, err := store.ListRoomConnections(ctx, room)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	result := PostToConnections(ctx, store, apigatewayClient, connectionList, data, skip)
+	if len(result.Gone) + result.Throttled + result.Unavailable + result.Failed > 0 {
+		log.Printf("broadcast to %s: %d delivered, %d gone, %d throttled, %d unavailable, %d failed", room, result.Delivered, len(result.Gone), result.Throttled, result.Unavailable, result.Failed)
+	}
+	return nil
+}
+
+// PostToConnections posts data to connectionList except skip through a Fanout,
+// deletes the connections that are gone and puts the metrics of the result.
+// The connections that failed otherwise are kept, as they may work again.
+func PostToConnections(ctx context.Context, store ConnectionStore, apigatewayClient ConnectionAPI, connectionList []Connection, data []byte, skip string) FanoutResult {
+	var connectionIdList []string
+	for _, item := range connectionList {
+		if item.ConnectionId != skip {
+			connectionIdList = append(connectionIdList, item.ConnectionId)
+		}
+	}
+	result := NewFanout(apigatewayClient).Post(ctx, connectionIdList, data)
+	// Delete lost-ConnectionId form dynamodb
+	for _, i := range result.Gone {
+		_ = store.DeleteConnection(ctx, i)
+	}
+	PutMetrics(result.Metrics())
+	return result
+}
+
+// BroadcastRoom posts data to room like Broadcast, but hands it to the broadcast
+// worker when the room has more than BROADCAST_ASYNC_SIZE connections, so that the
+// sender does not wait for a large room. Every frame to a room goes this way, as the
+// queue keeps the order only of the frames it carries: an edit or a reply count must
+// not overtake the message it is about. requestContext is that of the frame, from
+// which the worker reaches the WebSocket API. When the job can not be queued, the
+// error is returned rather than posting out of order.
+func BroadcastRoom(ctx context.Context, cfg aws.Config, store ConnectionStore, apigatewayClient ConnectionAPI, requestContext events.APIGatewayWebsocketProxyRequestContext, room string, data []byte, skip string) error {
+	connectionList, err := store.ListRoomConnections(ctx, room)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	asyncSize, _ := strconv.Atoi(os.Getenv("BROADCAST_ASYNC_SIZE"))
+	if queue := DefaultBroadcastQueue(cfg); queue != nil && asyncSize > 0 && len(connectionList) > asyncSize {
+		return queue.Enqueue(ctx, BroadcastJob{
+			Room:       room,
+			Data:       data,
+			Skip:       skip,
+			DomainName: requestContext.DomainName,
+			Stage:      requestContext.Stage,
+		})
+	}
+	PostToConnections(ctx, store, apigatewayClient, connectionList, data, skip)
+	return nil
+}
+
+// Announce tells the others in the room of c that c joined or left, through
+// BroadcastRoom so that it keeps its place among the other frames to the room.
+func Announce(ctx context.Context, cfg aws.Config, store ConnectionStore, apigatewayClient ConnectionAPI, requestContext events.APIGatewayWebsocketProxyRequestContext, event string, c Connection) error {
+	jsonBytes, err := NewEnvelope(TypeSystem, "", SystemPayload{
+		Event: event,
+		Room:  c.Room,
+		Name:  c.Name,
+	})
+	if err != nil {
+		return err
+	}
+	return BroadcastRoom(ctx, cfg, store, apigatewayClient, requestContext, c.Room, jsonBytes, c.ConnectionId)
+}
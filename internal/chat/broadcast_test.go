@@ -0,0 +1,40 @@
+package chat
+
+import (
+	"errors"
+	"context"
+	"testing"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat/chattest"
+)
+
+type failingQueue struct{}
+
+func (failingQueue) Enqueue(ctx context.Context, job BroadcastJob) error {
+	return ErrQueueFull
+}
+
+// A frame to a large room that can not be queued is not posted out of order.
+func TestBroadcastRoomQueueFull(t *testing.T) {
+	t.Setenv("BROADCAST_ASYNC_SIZE", "1")
+	SetDefaultBroadcastQueue(failingQueue{})
+	defer SetDefaultBroadcastQueue(nil)
+	ctx := context.Background()
+	store := NewMemoryStore(0)
+	for _, id := range []string{"a", "b"} {
+		if err := store.PutConnection(ctx, Connection{ConnectionId: id, Room: DefaultRoom}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &chattest.API{}
+	err := BroadcastRoom(ctx, aws.Config{}, store, api, events.APIGatewayWebsocketProxyRequestContext{}, DefaultRoom, []byte("x"), "")
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("BroadcastRoom = %v, want ErrQueueFull", err)
+	}
+	if n := api.PostCount(); n != 0 {
+		t.Errorf("%d frames were posted directly, want none", n)
+	}
+}
@@ -0,0 +1,319 @@
+package chat
+
+import (
+	"os"
+	"log"
+	"sync"
+	"time"
+	"errors"
+	"regexp"
+	"context"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// Connection is an open WebSocket connection. Typing is when its last typing
+// event was broadcast, in Unix milliseconds. SourceIp is the address it came from.
+type Connection struct {
+	ConnectionId string `dynamodbav:"connectionId"`
+	UserId       string `dynamodbav:"userId,omitempty"`
+	Name         string `dynamodbav:"name,omitempty"`
+	Room         string `dynamodbav:"room"`
+	Created      int64  `dynamodbav:"created"`
+	Color        string `dynamodbav:"color"`
+	Typing       int64  `dynamodbav:"typing,omitempty"`
+	SourceIp     string `dynamodbav:"sourceIp,omitempty"`
+}
+
+// MessageData is a stored message. Seq is unique in its room and increases with every
+// message of the room; Id is the slot the message occupies in the ring buffer of
+// LIMIT_MESSAGE_COUNT slots of the room (see MessageId).
+// ClientId is the id the sending client gave the message, used to drop resent copies.
+// Type is TypeMessage or TypeImage. Edited is when Data was last replaced, and
+// a deleted message is kept as a tombstone with Deleted set and no Data.
+// Reactions maps an emoji to the keys (see Connection.Key) of those who reacted with it.
+// A reply has the Seq of the first message of its thread as ParentId, and that message
+// counts its Replies. Replies are newer than their parent, so the ring buffer always
+// overwrites a parent before its replies; the replies left behind are orphans.
+type MessageData struct {
+	Id           string              `dynamodbav:"id"`
+	Seq          int                 `dynamodbav:"seq"`
+	Room         string              `dynamodbav:"room"`
+	Type         string              `dynamodbav:"type,omitempty"`
+	Data         string              `dynamodbav:"data"`
+	Created      int64               `dynamodbav:"created"`
+	ConnectionId string              `dynamodbav:"connectionId"`
+	UserId       string              `dynamodbav:"userId,omitempty"`
+	ClientId     string              `dynamodbav:"clientId,omitempty"`
+	Name         string              `dynamodbav:"name"`
+	Color        string              `dynamodbav:"color"`
+	Edited       int64               `dynamodbav:"edited,omitempty"`
+	Deleted      bool                `dynamodbav:"deleted,omitempty"`
+	Reactions    map[string][]string `dynamodbav:"reactions,omitempty"`
+	ParentId     int                 `dynamodbav:"parentId,omitempty"`
+	Replies      int                 `dynamodbav:"replies,omitempty"`
+}
+
+// MessageQuery selects the newest Limit messages of Room with Seq less than Before
+// and greater than Since, only the replies to ParentId if it is set, or the oldest
+// ones if Oldest is set. Zero values mean the default room, all messages, no bounds
+// and no limit.
+type MessageQuery struct {
+	Room     string
+	Before   int
+	Since    int
+	ParentId int
+	Limit    int
+	Oldest   bool
+}
+
+// ConnectionStore keeps the WebSocket connections that are currently open.
+// MarkTyping sets Typing of the connection to now unless it was set less than
+// interval ago, and reports whether it did. It is false for a missing connection.
+// ListUserConnections returns the connections opened by the signed-in user userId.
+// CountIpConnections returns how many connections came from sourceIp.
+type ConnectionStore interface {
+	GetConnectionCount(ctx context.Context) (int, error)
+	GetConnection(ctx context.Context, connectionId string) (Connection, error)
+	ListConnections(ctx context.Context) ([]Connection, error)
+	ListRoomConnections(ctx context.Context, room string) ([]Connection, error)
+	ListUserConnections(ctx context.Context, userId string) ([]Connection, error)
+	CountIpConnections(ctx context.Context, sourceIp string) (int, error)
+	PutConnection(ctx context.Context, item Connection) error
+	DeleteConnection(ctx context.Context, connectionId string) error
+	MarkTyping(ctx context.Context, connectionId string, now time.Time, interval time.Duration) (bool, error)
+}
+
+// MessageStore keeps the latest messages of each room and conversation. Once the limit
+// is reached, SaveMessage overwrites the oldest message of the room, never one of another.
+// It returns the message with Id and Seq set.
+// ListMessages returns messages in the order they were created.
+// FindClientMessage returns the message of room with clientId, or ErrNotFound. An empty room matches every room.
+// GetMessage, UpdateMessage, UpdateReaction and UpdateReplies return ErrNotFound once message seq of room was overwritten.
+// UpdateMessage writes Data, Edited and Deleted of item, found by its Room and Seq. UpdateReaction adds or removes
+// user from those who reacted to message seq with emoji, and returns how many they are.
+// UpdateReplies adds delta to the Replies of message seq and returns the new count.
+type MessageStore interface {
+	ListMessages(ctx context.Context, query MessageQuery) ([]MessageData, error)
+	SaveMessage(ctx context.Context, item MessageData) (MessageData, error)
+	FindClientMessage(ctx context.Context, room string, clientId string) (MessageData, error)
+	GetMessage(ctx context.Context, room string, seq int) (MessageData, error)
+	UpdateMessage(ctx context.Context, item MessageData) error
+	UpdateReaction(ctx context.Context, room string, seq int, emoji string, user string, add bool) (int, error)
+	UpdateReplies(ctx context.Context, room string, seq int, delta int) (int, error)
+}
+
+// RateLimitStore keeps the token buckets of rate limits.
+// TakeToken takes a token from the bucket of key and reports whether there was one.
+type RateLimitStore interface {
+	TakeToken(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, error)
+}
+
+type Store interface {
+	ConnectionStore
+	MessageStore
+	RateLimitStore
+}
+
+var ErrNotFound = errors.New("item not found")
+
+// DefaultRoom is the room used when none is given.
+const DefaultRoom string = "default"
+
+var roomPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+const maxEmojiLength int = 32
+
+const maxNameLength int = 20
+
+var defaultStore Store
+var defaultStoreMu sync.Mutex
+
+// DefaultStore returns the Store shared by the handlers in this process.
+// It is created by NewStore on first use unless SetDefaultStore was called.
+func DefaultStore(ctx context.Context) Store {
+	defaultStoreMu.Lock()
+	defer defaultStoreMu.Unlock()
+	if defaultStore == nil {
+		defaultStore = NewStore(ctx)
+	}
+	return defaultStore
+}
+
+func SetDefaultStore(s Store) {
+	defaultStoreMu.Lock()
+	defer defaultStoreMu.Unlock()
+	defaultStore = s
+}
+
+// NewStore returns the Store configured by the environment variables.
+// STORE_TYPE selects the backend: "dynamodb" (default), "memory" or "file".
+// The file backend writes to STORE_PATH.
+func NewStore(ctx context.Context) Store {
+	limitCount, _ := strconv.Atoi(os.Getenv("LIMIT_MESSAGE_COUNT"))
+	switch os.Getenv("STORE_TYPE") {
+	case "memory":
+		return NewMemoryStore(limitCount)
+	case "file":
+		s, err := NewFileStore(os.Getenv("STORE_PATH"), limitCount)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return s
+	}
+	return NewDynamoDBStore(GetConfig(ctx), os.Getenv("CONNECTION_TABLE_NAME"), os.Getenv("MESSAGE_TABLE_NAME"), os.Getenv("RATE_LIMIT_TABLE_NAME"), limitCount)
+}
+
+// ValidRoom reports whether room can be used as a room name.
+func ValidRoom(room string) bool {
+	return roomPattern.MatchString(room)
+}
+
+// ValidName reports whether name can be used as a display name:
+// 1 to 20 characters without surrounding spaces or control characters.
+func ValidName(name string) bool {
+	if name == "" || name != strings.TrimSpace(name) || utf8.RuneCountInString(name) > maxNameLength {
+		return false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidEmoji reports whether emoji can be used as a reaction: up to 32 bytes
+// without spaces or control characters.
+func ValidEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	for _, r := range emoji {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Key identifies who is behind c: the user if signed in, otherwise the connection.
+func (c Connection) Key() string {
+	if c.UserId != "" {
+		return "user:" + c.UserId
+	}
+	return "connection:" + c.ConnectionId
+}
+
+// ListKeyConnections returns the connections of the one identified by key,
+// which is the Key of a connection. It is empty if none is open.
+func ListKeyConnections(ctx context.Context, store ConnectionStore, key string)([]Connection, error) {
+	if userId, ok := strings.CutPrefix(key, "user:"); ok && userId != "" {
+		return store.ListUserConnections(ctx, userId)
+	}
+	connectionId, ok := strings.CutPrefix(key, "connection:")
+	if !ok || connectionId == "" {
+		return nil, nil
+	}
+	item, err := store.GetConnection(ctx, connectionId)
+	if errors.Is(err, ErrNotFound) || (err == nil && item.UserId != "") {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []Connection{item}, nil
+}
+
+// ConversationKey returns the room in which the direct messages between the
+// ones identified by keys a and b are saved. It does not depend on their order,
+// and never matches a room name.
+func ConversationKey(a string, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return "dm:" + a + "|" + b
+}
+
+// AuthorizerValue returns a string the authorizer put in the request context.
+func AuthorizerValue(authorizer interface{}, key string) string {
+	m, ok := authorizer.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	s, _ := m[key].(string)
+	return s
+}
+
+// OwnedBy reports whether c sent m. Messages of signed in users belong to the
+// user, others to the connection that sent them.
+func (m MessageData) OwnedBy(c Connection) bool {
+	if m.UserId != "" {
+		return m.UserId == c.UserId
+	}
+	return m.ConnectionId == c.ConnectionId
+}
+
+// ReactionCounts returns how many reacted to m with each emoji.
+func (m MessageData) ReactionCounts() map[string]int {
+	if len(m.Reactions) == 0 {
+		return nil
+	}
+	counts := map[string]int{}
+	for emoji, users := range m.Reactions {
+		if len(users) > 0 {
+			counts[emoji] = len(users)
+		}
+	}
+	return counts
+}
+
+// IsImage reports whether m is an uploaded image. Messages saved before Type
+// was stored are told by the URL of the bucket.
+func (m MessageData) IsImage() bool {
+	if m.Type != "" {
+		return m.Type == TypeImage
+	}
+	return strings.HasPrefix(m.Data, "https://" + os.Getenv("BUCKET_NAME"))
+}
+
+// MessageId returns the id of the ring buffer slot of message seq of room, like "default#3".
+// Each room and conversation has its own slots, so a busy room never overwrites the
+// messages of another. Without a limit every message has its own slot.
+func MessageId(room string, seq int, limitMessageCount int) string {
+	slot := seq
+	if limitMessageCount > 0 {
+		slot = (seq - 1) % limitMessageCount + 1
+	}
+	return room + "#" + strconv.Itoa(slot)
+}
+
+// CounterId returns the id of the item holding the last sequence number of room.
+// Slot 0 is never taken by a message.
+func CounterId(room string) string {
+	return room + "#0"
+}
+
+// Timestamp returns t as stored in the created and edited attributes: Unix time in milliseconds.
+// Before cmd/migrate, they held local time as digits like 20060102150405000.
+func Timestamp(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+// TimestampTime returns the time of a value made by Timestamp.
+func TimestampTime(ts int64) time.Time {
+	return time.UnixMilli(ts)
+}
+
+func GetConfig(ctx context.Context) aws.Config {
+	var err error
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(os.Getenv("REGION")))
+	if err != nil {
+		log.Print(err)
+	}
+	return cfg
+}
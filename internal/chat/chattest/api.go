@@ -0,0 +1,150 @@
+// Package chattest provides a fake API Gateway Management API for the tests of the handlers.
+package chattest
+
+import (
+	"sync"
+	"time"
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi/types"
+)
+
+// API is a chat.ConnectionAPI that answers like API Gateway. Every connection is
+// open until it is closed by Close or DeleteConnection, and is gone (410) then.
+// A post takes Latency, or Slow[connectionId] if set. Posts and GetConnection fail with
+// Errs[connectionId] if set. GetConnection reports LastActive[connectionId] as when the
+// connection was last active.
+// Set the fields before the API is used.
+type API struct {
+	Latency    time.Duration
+	Slow       map[string]time.Duration
+	Errs       map[string]error
+	LastActive map[string]time.Time
+
+	mu               sync.Mutex
+	closed           map[string]bool
+	posts            map[string][]string
+	postCount        int
+	inFlight         int
+	maxInFlight      int
+	retryMaxAttempts int
+}
+
+// Close closes connectionId, as if its client went away.
+func (a *API) Close(connectionId string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed == nil {
+		a.closed = map[string]bool{}
+	}
+	a.closed[connectionId] = true
+}
+
+// IsClosed reports whether connectionId was closed.
+func (a *API) IsClosed(connectionId string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closed[connectionId]
+}
+
+// Posts returns the data delivered to connectionId, in the order it was posted.
+func (a *API) Posts(connectionId string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.posts[connectionId]...)
+}
+
+// PostCount returns how many posts were made, including the failed ones.
+func (a *API) PostCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.postCount
+}
+
+// MaxInFlight returns the most posts that were made at the same time.
+func (a *API) MaxInFlight() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.maxInFlight
+}
+
+// RetryMaxAttempts returns the RetryMaxAttempts that the options of the last post set.
+func (a *API) RetryMaxAttempts() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.retryMaxAttempts
+}
+
+func (a *API) PostToConnection(ctx context.Context, params *apigatewaymanagementapi.PostToConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.PostToConnectionOutput, error) {
+	connectionId := aws.ToString(params.ConnectionId)
+	var options apigatewaymanagementapi.Options
+	for _, fn := range optFns {
+		fn(&options)
+	}
+	a.mu.Lock()
+	a.retryMaxAttempts = options.RetryMaxAttempts
+	a.postCount++
+	a.inFlight++
+	if a.inFlight > a.maxInFlight {
+		a.maxInFlight = a.inFlight
+	}
+	latency, ok := a.Slow[connectionId]
+	if !ok {
+		latency = a.Latency
+	}
+	err := a.Errs[connectionId]
+	if a.closed[connectionId] {
+		err = &types.GoneException{}
+	}
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.inFlight--
+		a.mu.Unlock()
+	}()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.posts == nil {
+		a.posts = map[string][]string{}
+	}
+	a.posts[connectionId] = append(a.posts[connectionId], string(params.Data))
+	return &apigatewaymanagementapi.PostToConnectionOutput{}, nil
+}
+
+func (a *API) GetConnection(ctx context.Context, params *apigatewaymanagementapi.GetConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.GetConnectionOutput, error) {
+	connectionId := aws.ToString(params.ConnectionId)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed[connectionId] {
+		return nil, &types.GoneException{}
+	}
+	if err := a.Errs[connectionId]; err != nil {
+		return nil, err
+	}
+	output := &apigatewaymanagementapi.GetConnectionOutput{}
+	if t, ok := a.LastActive[connectionId]; ok {
+		output.LastActiveAt = aws.Time(t)
+	}
+	return output, nil
+}
+
+func (a *API) DeleteConnection(ctx context.Context, params *apigatewaymanagementapi.DeleteConnectionInput, optFns ...func(*apigatewaymanagementapi.Options)) (*apigatewaymanagementapi.DeleteConnectionOutput, error) {
+	connectionId := aws.ToString(params.ConnectionId)
+	if a.IsClosed(connectionId) {
+		return nil, &types.GoneException{}
+	}
+	a.Close(connectionId)
+	return &apigatewaymanagementapi.DeleteConnectionOutput{}, nil
+}
@@ -0,0 +1,707 @@
+package chat
+
+import (
+	"log"
+	"errors"
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+)
+
+// messageSeqIndex is the index of the message table sorted by seq in each room.
+const messageSeqIndex string = "room-seq-index"
+
+// messageParentIndex is the index of the message table sorted by seq for each parentId.
+// Only replies are in it.
+const messageParentIndex string = "parentId-seq-index"
+
+// messageClientIndex is the index of the message table by clientId.
+// Messages without clientId are not in it.
+const messageClientIndex string = "clientId-index"
+
+// connectionRoomIndex is the index of the connection table by room.
+const connectionRoomIndex string = "room-index"
+
+// connectionUserIndex is the index of the connection table by userId.
+// Only the connections of signed-in users are in it.
+const connectionUserIndex string = "userId-index"
+
+// connectionIpIndex is the index of the connection table by sourceIp. It only
+// projects the keys, as it is used to count the connections from an address.
+const connectionIpIndex string = "sourceIp-index"
+
+// saveMessageRetry is how many sequence numbers SaveMessage takes for a message.
+// A slot is only taken by a newer message when the writer of an older one was
+// overtaken by a whole ring of others, so the next number is almost always free.
+const saveMessageRetry int = 3
+
+// takeTokenRetry is how many times TakeToken reads a bucket again after another
+// request updated it first. When they all lose, the bucket still had a token each time,
+// so the frame is let through rather than rejected.
+const takeTokenRetry int = 3
+
+type DynamoDBStore struct {
+	client            *dynamodb.Client
+	connectionTable   string
+	messageTable      string
+	rateLimitTable    string
+	limitMessageCount int
+}
+
+func NewDynamoDBStore(cfg aws.Config, connectionTable string, messageTable string, rateLimitTable string, limitMessageCount int) *DynamoDBStore {
+	return &DynamoDBStore{
+		client:            dynamodb.NewFromConfig(cfg),
+		connectionTable:   connectionTable,
+		messageTable:      messageTable,
+		rateLimitTable:    rateLimitTable,
+		limitMessageCount: limitMessageCount,
+	}
+}
+
+func (s *DynamoDBStore) scan(ctx context.Context, tableName string)(*dynamodb.ScanOutput, error)  {
+	params := &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	}
+	return s.client.Scan(ctx, params)
+}
+
+func (s *DynamoDBStore) put(ctx context.Context, tableName string, av map[string]types.AttributeValue) error {
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(tableName),
+	}
+	_, err := s.client.PutItem(ctx, input)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+func (s *DynamoDBStore) get(ctx context.Context, tableName string, key map[string]types.AttributeValue)(*dynamodb.GetItemOutput, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: key,
+		ConsistentRead: aws.Bool(true),
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityNone,
+	}
+	return s.client.GetItem(ctx, input)
+}
+
+func (s *DynamoDBStore) update(ctx context.Context, tableName string, an map[string]string, av map[string]types.AttributeValue, key map[string]types.AttributeValue, updateExpression string)(*dynamodb.UpdateItemOutput, error) {
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: an,
+		ExpressionAttributeValues: av,
+		TableName: aws.String(tableName),
+		Key: key,
+		ReturnValues:     types.ReturnValueUpdatedNew,
+		UpdateExpression: aws.String(updateExpression),
+	}
+
+	return s.client.UpdateItem(ctx, input)
+}
+
+func (s *DynamoDBStore) delete(ctx context.Context, tableName string, key map[string]types.AttributeValue) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: key,
+	}
+
+	_, err := s.client.DeleteItem(ctx, input)
+	return err
+}
+
+func (s *DynamoDBStore) GetConnectionCount(ctx context.Context)(int, error) {
+	result, err := s.scan(ctx, s.connectionTable)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.ScannedCount), nil
+}
+
+func (s *DynamoDBStore) GetConnection(ctx context.Context, connectionId string)(Connection, error) {
+	var item Connection
+	key, err := attributevalue.MarshalMap(struct {ConnectionId string `dynamodbav:"connectionId"`}{connectionId})
+	if err != nil {
+		return item, err
+	}
+	result, err := s.get(ctx, s.connectionTable, key)
+	if err != nil {
+		return item, err
+	}
+	if result.Item == nil {
+		return item, ErrNotFound
+	}
+	err = attributevalue.UnmarshalMap(result.Item, &item)
+	return item, err
+}
+
+func (s *DynamoDBStore) ListConnections(ctx context.Context)([]Connection, error) {
+	result, err := s.scan(ctx, s.connectionTable)
+	if err != nil {
+		return nil, err
+	}
+	var connectionList []Connection
+	for _, i := range result.Items {
+		item := Connection{}
+		err = attributevalue.UnmarshalMap(i, &item)
+		if err != nil {
+			log.Println(err)
+		} else {
+			connectionList = append(connectionList, item)
+		}
+	}
+	return connectionList, nil
+}
+
+func (s *DynamoDBStore) ListRoomConnections(ctx context.Context, room string)([]Connection, error) {
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(s.connectionTable),
+		IndexName: aws.String(connectionRoomIndex),
+		KeyConditionExpression: aws.String("#r = :room"),
+		ExpressionAttributeNames: map[string]string{
+			"#r": "room",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":room": &types.AttributeValueMemberS{Value: room},
+		},
+	}
+	var connectionList []Connection
+	for {
+		result, err := s.client.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range result.Items {
+			item := Connection{}
+			err = attributevalue.UnmarshalMap(i, &item)
+			if err != nil {
+				log.Println(err)
+			} else {
+				connectionList = append(connectionList, item)
+			}
+		}
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	return connectionList, nil
+}
+
+func (s *DynamoDBStore) ListUserConnections(ctx context.Context, userId string)([]Connection, error) {
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(s.connectionTable),
+		IndexName: aws.String(connectionUserIndex),
+		KeyConditionExpression: aws.String("#u = :userId"),
+		ExpressionAttributeNames: map[string]string{
+			"#u": "userId",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userId},
+		},
+	}
+	var connectionList []Connection
+	for {
+		result, err := s.client.Query(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range result.Items {
+			item := Connection{}
+			err = attributevalue.UnmarshalMap(i, &item)
+			if err != nil {
+				log.Println(err)
+			} else {
+				connectionList = append(connectionList, item)
+			}
+		}
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	return connectionList, nil
+}
+
+func (s *DynamoDBStore) CountIpConnections(ctx context.Context, sourceIp string)(int, error) {
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(s.connectionTable),
+		IndexName: aws.String(connectionIpIndex),
+		KeyConditionExpression: aws.String("#s = :sourceIp"),
+		ExpressionAttributeNames: map[string]string{
+			"#s": "sourceIp",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sourceIp": &types.AttributeValueMemberS{Value: sourceIp},
+		},
+		Select: types.SelectCount,
+	}
+	count := 0
+	for {
+		result, err := s.client.Query(ctx, input)
+		if err != nil {
+			return 0, err
+		}
+		count += int(result.Count)
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	return count, nil
+}
+
+func (s *DynamoDBStore) PutConnection(ctx context.Context, item Connection) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return s.put(ctx, s.connectionTable, av)
+}
+
+func (s *DynamoDBStore) DeleteConnection(ctx context.Context, connectionId string) error {
+	item := struct {ConnectionId string `dynamodbav:"connectionId"`}{connectionId}
+	key, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+	return s.delete(ctx, s.connectionTable, key)
+}
+
+// MarkTyping is a conditional update, so only one of concurrent calls succeeds.
+func (s *DynamoDBStore) MarkTyping(ctx context.Context, connectionId string, now time.Time, interval time.Duration)(bool, error) {
+	key, err := attributevalue.MarshalMap(struct {ConnectionId string `dynamodbav:"connectionId"`}{connectionId})
+	if err != nil {
+		return false, err
+	}
+	_, err = s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.connectionTable),
+		Key: key,
+		UpdateExpression: aws.String("SET #t = :now"),
+		ConditionExpression: aws.String("attribute_exists(#i) AND (attribute_not_exists(#t) OR #t <= :threshold)"),
+		ExpressionAttributeNames: map[string]string{
+			"#i": "connectionId",
+			"#t": "typing",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+			":threshold": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(-interval).UnixMilli(), 10)},
+		},
+	})
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return false, nil
+	} else if err != nil {
+		log.Print(err)
+		return false, err
+	}
+	return true, nil
+}
+
+// TakeToken reads the bucket of key and writes it back with a token taken,
+// on condition that it was not updated in between. An empty bucket is always
+// reported as such; only a frame that keeps losing the race is let through.
+func (s *DynamoDBStore) TakeToken(ctx context.Context, key string, limit RateLimit, now time.Time)(bool, error) {
+	k, err := attributevalue.MarshalMap(struct {Key string `dynamodbav:"key"`}{key})
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < takeTokenRetry; i++ {
+		result, err := s.get(ctx, s.rateLimitTable, k)
+		if err != nil {
+			log.Print(err)
+			return false, err
+		}
+		bucket := Bucket{Key: key}
+		if result.Item != nil {
+			if err = attributevalue.UnmarshalMap(result.Item, &bucket); err != nil {
+				return false, err
+			}
+		}
+		updated := bucket.Updated
+		if !bucket.Take(limit, now) {
+			return false, nil
+		}
+		av, err := attributevalue.MarshalMap(bucket)
+		if err != nil {
+			return false, err
+		}
+		_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(s.rateLimitTable),
+			Item: av,
+			ConditionExpression: aws.String("attribute_not_exists(#k) OR #u = :updated"),
+			ExpressionAttributeNames: map[string]string{
+				"#k": "key",
+				"#u": "updated",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":updated": &types.AttributeValueMemberN{Value: strconv.FormatInt(updated, 10)},
+			},
+		})
+		var conditionalCheckFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionalCheckFailed) {
+			continue
+		} else if err != nil {
+			log.Print(err)
+			return false, err
+		}
+		return true, nil
+	}
+	return true, nil
+}
+
+// ListMessages queries the newest messages first, or the oldest with query.Oldest,
+// and follows LastEvaluatedKey until query.Limit messages are read, then returns
+// them oldest first.
+// It queries messageSeqIndex, or messageParentIndex filtered by room with query.ParentId;
+// both are sorted by seq, so query.Since and query.Before are key conditions.
+func (s *DynamoDBStore) ListMessages(ctx context.Context, query MessageQuery)([]MessageData, error) {
+	room := query.Room
+	if room == "" {
+		room = DefaultRoom
+	}
+	if query.Since > 0 && query.Before > 0 && query.Since + 1 >= query.Before {
+		return nil, nil
+	}
+	an := map[string]string{
+		"#r": "room",
+	}
+	av := map[string]types.AttributeValue{
+		":room": &types.AttributeValueMemberS{Value: room},
+	}
+	keyCondition := "#r = :room"
+	indexName := messageSeqIndex
+	var filter *string
+	if query.ParentId > 0 {
+		indexName = messageParentIndex
+		an["#p"] = "parentId"
+		av[":parent"] = &types.AttributeValueMemberN{Value: strconv.Itoa(query.ParentId)}
+		keyCondition = "#p = :parent"
+		filter = aws.String("#r = :room")
+	}
+	switch {
+	case query.Since > 0 && query.Before > 0:
+		an["#s"] = "seq"
+		av[":since"] = &types.AttributeValueMemberN{Value: strconv.Itoa(query.Since + 1)}
+		av[":before"] = &types.AttributeValueMemberN{Value: strconv.Itoa(query.Before - 1)}
+		keyCondition += " AND #s BETWEEN :since AND :before"
+	case query.Since > 0:
+		an["#s"] = "seq"
+		av[":since"] = &types.AttributeValueMemberN{Value: strconv.Itoa(query.Since)}
+		keyCondition += " AND #s > :since"
+	case query.Before > 0:
+		an["#s"] = "seq"
+		av[":before"] = &types.AttributeValueMemberN{Value: strconv.Itoa(query.Before)}
+		keyCondition += " AND #s < :before"
+	}
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(s.messageTable),
+		IndexName: aws.String(indexName),
+		KeyConditionExpression: aws.String(keyCondition),
+		FilterExpression: filter,
+		ExpressionAttributeNames: an,
+		ExpressionAttributeValues: av,
+		ScanIndexForward: aws.Bool(query.Oldest),
+	}
+	var messageList []MessageData
+	for {
+		if query.Limit > 0 {
+			input.Limit = aws.Int32(int32(query.Limit - len(messageList)))
+		}
+		result, err := s.client.Query(ctx, input)
+		if err != nil {
+			log.Print(err)
+			return nil, err
+		}
+		for _, i := range result.Items {
+			item := MessageData{}
+			err := attributevalue.UnmarshalMap(i, &item)
+			if err != nil {
+				log.Print(err)
+			} else {
+				messageList = append(messageList, item)
+			}
+		}
+		if result.LastEvaluatedKey == nil || (query.Limit > 0 && len(messageList) >= query.Limit) {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	if !query.Oldest {
+		for i, j := 0, len(messageList) - 1; i < j; i, j = i + 1, j - 1 {
+			messageList[i], messageList[j] = messageList[j], messageList[i]
+		}
+	}
+	return messageList, nil
+}
+
+// SaveMessage takes the next sequence number from the counter item of the room and
+// writes the message to its slot. The condition keeps a slow writer from overwriting a newer
+// message that already reused the slot; the message then takes the next number instead.
+func (s *DynamoDBStore) SaveMessage(ctx context.Context, item MessageData)(MessageData, error) {
+	if item.Room == "" {
+		item.Room = DefaultRoom
+	}
+	for i := 0; i < saveMessageRetry; i++ {
+		seq, err := s.nextSeq(ctx, item.Room)
+		if err != nil {
+			log.Print(err)
+			return item, err
+		}
+		item.Seq = seq
+		item.Id = MessageId(item.Room, seq, s.limitMessageCount)
+		av, err := attributevalue.MarshalMap(item)
+		if err != nil {
+			log.Print(err)
+			return item, err
+		}
+		input := &dynamodb.PutItemInput{
+			Item:      av,
+			TableName: aws.String(s.messageTable),
+			ConditionExpression: aws.String("attribute_not_exists(#s) OR #s < :seq"),
+			ExpressionAttributeNames: map[string]string{
+				"#s": "seq",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":seq": &types.AttributeValueMemberN{Value: strconv.Itoa(seq)},
+			},
+		}
+		_, err = s.client.PutItem(ctx, input)
+		var conditionalCheckFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionalCheckFailed) {
+			continue
+		} else if err != nil {
+			log.Print(err)
+			return item, err
+		}
+		return item, nil
+	}
+	err := errors.New("message slots of " + item.Room + " were taken by newer messages")
+	log.Print(err)
+	return item, err
+}
+
+// FindClientMessage queries messageClientIndex. The index is eventually consistent,
+// so a copy resent right after the first one may not be found.
+func (s *DynamoDBStore) FindClientMessage(ctx context.Context, room string, clientId string)(MessageData, error) {
+	var item MessageData
+	input := &dynamodb.QueryInput{
+		TableName: aws.String(s.messageTable),
+		IndexName: aws.String(messageClientIndex),
+		KeyConditionExpression: aws.String("#c = :clientId"),
+		ExpressionAttributeNames: map[string]string{
+			"#c": "clientId",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":clientId": &types.AttributeValueMemberS{Value: clientId},
+		},
+	}
+	if room != "" {
+		input.FilterExpression = aws.String("#r = :room")
+		input.ExpressionAttributeNames["#r"] = "room"
+		input.ExpressionAttributeValues[":room"] = &types.AttributeValueMemberS{Value: room}
+	}
+	for {
+		result, err := s.client.Query(ctx, input)
+		if err != nil {
+			log.Print(err)
+			return item, err
+		}
+		if len(result.Items) > 0 {
+			err = attributevalue.UnmarshalMap(result.Items[0], &item)
+			return item, err
+		}
+		if result.LastEvaluatedKey == nil {
+			break
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+	return item, ErrNotFound
+}
+
+// GetMessage reads the slot of seq and checks that it still holds that message.
+func (s *DynamoDBStore) GetMessage(ctx context.Context, room string, seq int)(MessageData, error) {
+	var item MessageData
+	key, err := s.messageKey(room, seq)
+	if err != nil {
+		return item, err
+	}
+	result, err := s.get(ctx, s.messageTable, key)
+	if err != nil {
+		return item, err
+	}
+	if result.Item == nil {
+		return item, ErrNotFound
+	}
+	if err = attributevalue.UnmarshalMap(result.Item, &item); err != nil {
+		return item, err
+	}
+	if item.Seq != seq {
+		return MessageData{}, ErrNotFound
+	}
+	return item, nil
+}
+
+// UpdateMessage is conditional on seq, so it never changes a newer message in the same slot.
+func (s *DynamoDBStore) UpdateMessage(ctx context.Context, item MessageData) error {
+	key, err := s.messageKey(item.Room, item.Seq)
+	if err != nil {
+		return err
+	}
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.messageTable),
+		Key: key,
+		UpdateExpression: aws.String("SET #d = :data, #e = :edited, #x = :deleted"),
+		ConditionExpression: aws.String("#s = :seq"),
+		ExpressionAttributeNames: map[string]string{
+			"#d": "data",
+			"#e": "edited",
+			"#x": "deleted",
+			"#s": "seq",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":data": &types.AttributeValueMemberS{Value: item.Data},
+			":edited": &types.AttributeValueMemberN{Value: strconv.FormatInt(item.Edited, 10)},
+			":deleted": &types.AttributeValueMemberBOOL{Value: item.Deleted},
+			":seq": &types.AttributeValueMemberN{Value: strconv.Itoa(item.Seq)},
+		},
+	}
+	_, err = s.client.UpdateItem(ctx, input)
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return ErrNotFound
+	} else if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
+
+// UpdateReaction adds user to or deletes it from the string set of emoji in the
+// reactions map, so concurrent reactions never overwrite each other. A path in
+// the map can only be updated once the map exists, so it is created first.
+func (s *DynamoDBStore) UpdateReaction(ctx context.Context, room string, seq int, emoji string, user string, add bool)(int, error) {
+	key, err := s.messageKey(room, seq)
+	if err != nil {
+		return 0, err
+	}
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	_, err = s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.messageTable),
+		Key: key,
+		UpdateExpression: aws.String("SET #r = if_not_exists(#r, :empty)"),
+		ConditionExpression: aws.String("#s = :seq"),
+		ExpressionAttributeNames: map[string]string{
+			"#r": "reactions",
+			"#s": "seq",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":empty": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}},
+			":seq": &types.AttributeValueMemberN{Value: strconv.Itoa(seq)},
+		},
+	})
+	if errors.As(err, &conditionalCheckFailed) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	updateExpression := "ADD #r.#e :user"
+	if !add {
+		updateExpression = "DELETE #r.#e :user"
+	}
+	result, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.messageTable),
+		Key: key,
+		UpdateExpression: aws.String(updateExpression),
+		ConditionExpression: aws.String("#s = :seq"),
+		ExpressionAttributeNames: map[string]string{
+			"#r": "reactions",
+			"#e": emoji,
+			"#s": "seq",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user": &types.AttributeValueMemberSS{Value: []string{user}},
+			":seq": &types.AttributeValueMemberN{Value: strconv.Itoa(seq)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if errors.As(err, &conditionalCheckFailed) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	// An emptied set is removed, and then nothing is returned.
+	updated := struct {Reactions map[string][]string `dynamodbav:"reactions"`}{}
+	if err = attributevalue.UnmarshalMap(result.Attributes, &updated); err != nil {
+		return 0, err
+	}
+	return len(updated.Reactions[emoji]), nil
+}
+
+func (s *DynamoDBStore) UpdateReplies(ctx context.Context, room string, seq int, delta int)(int, error) {
+	key, err := s.messageKey(room, seq)
+	if err != nil {
+		return 0, err
+	}
+	result, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.messageTable),
+		Key: key,
+		UpdateExpression: aws.String("ADD #n :delta"),
+		ConditionExpression: aws.String("#s = :seq"),
+		ExpressionAttributeNames: map[string]string{
+			"#n": "replies",
+			"#s": "seq",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":delta": &types.AttributeValueMemberN{Value: strconv.Itoa(delta)},
+			":seq": &types.AttributeValueMemberN{Value: strconv.Itoa(seq)},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return 0, ErrNotFound
+	} else if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	updated := struct {Replies int `dynamodbav:"replies"`}{}
+	err = attributevalue.UnmarshalMap(result.Attributes, &updated)
+	return updated.Replies, err
+}
+
+// messageKey returns the key of the slot of message seq of room.
+func (s *DynamoDBStore) messageKey(room string, seq int)(map[string]types.AttributeValue, error) {
+	return attributevalue.MarshalMap(struct {Id string `dynamodbav:"id"`}{MessageId(room, seq, s.limitMessageCount)})
+}
+
+// nextSeq atomically increments the counter item of room and returns the new value.
+// The counter item has no room attribute, so it never appears in messageSeqIndex.
+func (s *DynamoDBStore) nextSeq(ctx context.Context, room string)(int, error) {
+	an := map[string]string{
+		"#s": "seq",
+	}
+	av := map[string]types.AttributeValue{
+		":one": &types.AttributeValueMemberN{Value: "1"},
+	}
+	key, err := attributevalue.MarshalMap(struct {Id string `dynamodbav:"id"`}{CounterId(room)})
+	if err != nil {
+		return 0, err
+	}
+	result, err := s.update(ctx, s.messageTable, an, av, key, "ADD #s :one")
+	if err != nil {
+		return 0, err
+	}
+	counter := struct {Seq int `dynamodbav:"seq"`}{}
+	err = attributevalue.UnmarshalMap(result.Attributes, &counter)
+	return counter.Seq, err
+}
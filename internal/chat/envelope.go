@@ -0,0 +1,261 @@
+package chat
+
+import (
+	"time"
+	"errors"
+	"encoding/json"
+)
+
+// EnvelopeVersion is the version of the WebSocket protocol written in "v".
+const EnvelopeVersion int = 1
+
+// Types of envelope payloads.
+const (
+	TypeMessage  string = "message"
+	TypeImage    string = "image"
+	TypeSystem   string = "system"
+	TypeError    string = "error"
+	TypePresence string = "presence"
+	TypeAck      string = "ack"
+	TypeNack     string = "nack"
+	TypeSync     string = "sync"
+	TypeEdit     string = "edit"
+	TypeEdited   string = "edited"
+	TypeDelete   string = "delete"
+	TypeDeleted  string = "deleted"
+	TypeReact    string = "react"
+	TypeReaction string = "reaction"
+	TypeThread   string = "thread"
+	TypeTyping   string = "typing"
+	TypeWho      string = "who"
+	TypeDm       string = "dm"
+)
+
+// Envelope is every frame sent over the WebSocket in both directions.
+// Action is only set by clients, because API Gateway selects the route by it.
+type Envelope struct {
+	Action  string          `json:"action,omitempty"`
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	Id      string          `json:"id,omitempty"`
+	Ts      int64           `json:"ts"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type MessagePayload struct {
+	Seq       int            `json:"seq,omitempty"`
+	Room      string         `json:"room,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Color     string         `json:"color,omitempty"`
+	Created   int64          `json:"created,omitempty"`
+	Text      string         `json:"text"`
+	Edited    bool           `json:"edited,omitempty"`
+	Deleted   bool           `json:"deleted,omitempty"`
+	Reactions map[string]int `json:"reactions,omitempty"`
+	ParentId  int            `json:"parentId,omitempty"`
+	Replies   int            `json:"replies,omitempty"`
+}
+
+// ImagePayload carries Data, a data URL, and Filename from the client,
+// and Url of the uploaded image to the clients.
+type ImagePayload struct {
+	Seq       int            `json:"seq,omitempty"`
+	Room      string         `json:"room,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Color     string         `json:"color,omitempty"`
+	Created   int64          `json:"created,omitempty"`
+	Filename  string         `json:"filename,omitempty"`
+	Data      string         `json:"data,omitempty"`
+	Url       string         `json:"url,omitempty"`
+	Deleted   bool           `json:"deleted,omitempty"`
+	Reactions map[string]int `json:"reactions,omitempty"`
+	ParentId  int            `json:"parentId,omitempty"`
+	Replies   int            `json:"replies,omitempty"`
+}
+
+// SystemPayload tells of Event in Room. A synced event has More set when
+// messages after Seq were left for another sync.
+type SystemPayload struct {
+	Event string `json:"event"`
+	Room  string `json:"room,omitempty"`
+	Seq   int    `json:"seq,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Text  string `json:"text,omitempty"`
+	More  bool   `json:"more,omitempty"`
+}
+
+// EditPayload asks to replace the text of message Seq, and in an edited
+// frame tells the clients that it was replaced.
+type EditPayload struct {
+	Seq  int    `json:"seq"`
+	Text string `json:"text"`
+}
+
+// DeletePayload asks to delete message Seq, and in a deleted frame tells
+// the clients that it was deleted.
+type DeletePayload struct {
+	Seq int `json:"seq"`
+}
+
+// ReactionPayload asks to add, or with Remove to remove, a reaction to message Seq.
+// In a reaction frame it tells the clients who changed it and the new Count.
+type ReactionPayload struct {
+	Seq    int    `json:"seq"`
+	Emoji  string `json:"emoji"`
+	Remove bool   `json:"remove,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Count  int    `json:"count"`
+}
+
+// ThreadPayload asks for the replies to ParentId. From the server it tells
+// how many Replies the thread has.
+type ThreadPayload struct {
+	ParentId int `json:"parentId"`
+	Replies  int `json:"replies"`
+}
+
+// DirectPayload asks to send Text to the one identified by To, the id of a
+// presence user. To the two of them it tells who sent it in From, Name and Color.
+type DirectPayload struct {
+	Seq   int    `json:"seq,omitempty"`
+	To    string `json:"to"`
+	From  string `json:"from,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Color string `json:"color,omitempty"`
+	Text  string `json:"text"`
+}
+
+// TypingPayload tells the clients who is typing. Clients send it empty.
+type TypingPayload struct {
+	Name  string `json:"name,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+// SyncPayload asks for the messages saved after Since.
+type SyncPayload struct {
+	Since int `json:"since"`
+}
+
+// AckPayload confirms that the frame with the same id was saved as message Seq at Ts.
+type AckPayload struct {
+	Seq int   `json:"seq"`
+	Ts  int64 `json:"ts"`
+}
+
+// ErrorPayload is the payload of error and nack frames.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PresenceUser is a participant of a room. Id is the Key of its connection, to which
+// direct messages are sent. Since is when it connected, in Unix milliseconds.
+type PresenceUser struct {
+	Id    string `json:"id,omitempty"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+	Since int64  `json:"since,omitempty"`
+}
+
+// PresencePayload answers a who frame with the participants of Room.
+type PresencePayload struct {
+	Room  string         `json:"room"`
+	Users []PresenceUser `json:"users"`
+}
+
+// legacyPost is the body sent by clients before the envelope.
+type legacyPost struct {
+	Text  string `json:"text"`
+	Image string `json:"image"`
+}
+
+var ErrUnsupportedVersion = errors.New("unsupported envelope version")
+
+// NewEnvelope encodes payload in an envelope of typ, stamped with the current time.
+func NewEnvelope(typ string, id string, payload interface{})([]byte, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{
+		V:       EnvelopeVersion,
+		Type:    typ,
+		Id:      id,
+		Ts:      time.Now().UnixMilli(),
+		Payload: b,
+	})
+}
+
+// MessageEnvelope encodes a stored message as a message or image envelope with id.
+func MessageEnvelope(id string, item MessageData)([]byte, error) {
+	if item.IsImage() {
+		return NewEnvelope(TypeImage, id, ImagePayload{
+			Seq:       item.Seq,
+			Room:      item.Room,
+			Name:      item.Name,
+			Color:     item.Color,
+			Created:   item.Created,
+			Url:       item.Data,
+			Deleted:   item.Deleted,
+			Reactions: item.ReactionCounts(),
+			ParentId:  item.ParentId,
+			Replies:   item.Replies,
+		})
+	}
+	return NewEnvelope(TypeMessage, id, MessagePayload{
+		Seq:       item.Seq,
+		Room:      item.Room,
+		Name:      item.Name,
+		Color:     item.Color,
+		Created:   item.Created,
+		Text:      item.Data,
+		Edited:    item.Edited > 0,
+		Deleted:   item.Deleted,
+		Reactions: item.ReactionCounts(),
+		ParentId:  item.ParentId,
+		Replies:   item.Replies,
+	})
+}
+
+// DecodeEnvelope parses a frame from a client. A body without "v" is read as
+// the former {"action", "text", "image"} shape and converted to a message or
+// image envelope.
+func DecodeEnvelope(body []byte)(Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(body, &e); err != nil {
+		return e, err
+	}
+	if e.V > EnvelopeVersion {
+		return e, ErrUnsupportedVersion
+	}
+	if e.V > 0 {
+		return e, nil
+	}
+	var legacy legacyPost
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return e, err
+	}
+	var payload interface{}
+	if len(legacy.Image) > 0 {
+		e.Type = TypeImage
+		payload = ImagePayload{Filename: legacy.Text, Data: legacy.Image}
+	} else {
+		e.Type = TypeMessage
+		payload = MessagePayload{Text: legacy.Text}
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return e, err
+	}
+	e.V = EnvelopeVersion
+	e.Payload = b
+	return e, nil
+}
+
+// Decode unmarshals the payload of e into v.
+func (e Envelope) Decode(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return errors.New("envelope has no payload")
+	}
+	return json.Unmarshal(e.Payload, v)
+}
@@ -0,0 +1,58 @@
+package chat
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	tests := []struct {
+		body     string
+		typ      string
+		text     string
+		filename string
+		image    string
+	}{
+		{`{"action": "sendmessage", "text": "hello"}`, TypeMessage, "hello", "", ""},
+		{`{"action": "sendmessage", "text": "cat.png", "image": "data:image/png;base64,AAAA"}`, TypeImage, "", "cat.png", "data:image/png;base64,AAAA"},
+		{`{"action": "send", "v": 1, "type": "message", "id": "m1", "payload": {"text": "hi"}}`, TypeMessage, "hi", "", ""},
+	}
+	for _, tt := range tests {
+		e, err := DecodeEnvelope([]byte(tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if e.V != EnvelopeVersion || e.Type != tt.typ {
+			t.Errorf("%s: v %d type %q, want v %d type %q", tt.body, e.V, e.Type, EnvelopeVersion, tt.typ)
+		}
+		if tt.typ == TypeImage {
+			var p ImagePayload
+			if err = e.Decode(&p); err != nil || p.Filename != tt.filename || p.Data != tt.image {
+				t.Errorf("%s: payload %+v, %v", tt.body, p, err)
+			}
+		} else {
+			var p MessagePayload
+			if err = e.Decode(&p); err != nil || p.Text != tt.text {
+				t.Errorf("%s: payload %+v, %v", tt.body, p, err)
+			}
+		}
+	}
+}
+
+func TestDecodeEnvelopeErrors(t *testing.T) {
+	if _, err := DecodeEnvelope([]byte(`{"v": 2, "type": "message", "payload": {}}`)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("newer version: %v, want ErrUnsupportedVersion", err)
+	}
+	if _, err := DecodeEnvelope([]byte(`not json`)); err == nil {
+		t.Error("invalid JSON was decoded")
+	}
+	e, err := DecodeEnvelope([]byte(`{"v": 1, "type": "message"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p MessagePayload
+	if err = e.Decode(&p); err == nil {
+		t.Error("an envelope without payload was decoded")
+	}
+}
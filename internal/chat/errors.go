@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"errors"
+)
+
+// Codes of error frames. They are part of the protocol and must not change.
+const (
+	CodeInvalidPayload   string = "invalid_payload"
+	CodeUnsupportedMedia string = "unsupported_media"
+	CodeRateLimited      string = "rate_limited"
+	CodeNotFound         string = "not_found"
+	CodeForbidden        string = "forbidden"
+	CodeInternal         string = "internal_error"
+)
+
+// FrameError is an error to be reported to the client with Code.
+type FrameError struct {
+	Code string
+	Err  error
+}
+
+func (e *FrameError) Error() string {
+	return e.Code + ": " + e.Err.Error()
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// NewFrameError returns err annotated with code.
+func NewFrameError(code string, err error) error {
+	return &FrameError{Code: code, Err: err}
+}
+
+// ErrorPayloadOf returns the payload of the error frame for err. Errors
+// without a code are internal, and their details are not sent to clients.
+func ErrorPayloadOf(err error) ErrorPayload {
+	var frameError *FrameError
+	if errors.As(err, &frameError) && frameError.Code != CodeInternal {
+		return ErrorPayload{Code: frameError.Code, Message: frameError.Err.Error()}
+	}
+	return ErrorPayload{Code: CodeInternal, Message: "internal error"}
+}
@@ -0,0 +1,210 @@
+package chat
+
+import (
+	"log"
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"math/rand"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi/types"
+)
+
+// Defaults of Fanout.
+const (
+	DefaultFanoutWorkers  int           = 16
+	DefaultFanoutTimeout  time.Duration = 3 * time.Second
+	DefaultFanoutAttempts int           = 3
+	DefaultFanoutBackoff  time.Duration = 100 * time.Millisecond
+)
+
+// Fanout posts a frame to many connections at once. At most Workers posts are
+// in flight, and each one is given up after Timeout, so a slow connection only
+// delays itself. A post that was throttled or hit a server error is tried up to
+// Attempts times, waiting Backoff and then twice as long each time.
+type Fanout struct {
+	Api      ConnectionAPI
+	Workers  int
+	Timeout  time.Duration
+	Attempts int
+	Backoff  time.Duration
+}
+
+// FanoutResult counts how the posts of a Fanout went. Gone lists the connections
+// that API Gateway reported as closed. Throttled and Unavailable count the posts
+// that were still throttled or failing with a server error after the last attempt,
+// and Failed the others that can not succeed. Retries counts the extra attempts.
+type FanoutResult struct {
+	Delivered   int
+	Gone        []string
+	Throttled   int
+	Unavailable int
+	Failed      int
+	Retries     int
+}
+
+// Outcomes of a post.
+const (
+	postDelivered int = iota
+	postGone
+	postThrottled
+	postUnavailable
+	postFailed
+)
+
+func NewFanout(apigatewayClient ConnectionAPI) *Fanout {
+	return &Fanout{
+		Api:      apigatewayClient,
+		Workers:  DefaultFanoutWorkers,
+		Timeout:  DefaultFanoutTimeout,
+		Attempts: DefaultFanoutAttempts,
+		Backoff:  DefaultFanoutBackoff,
+	}
+}
+
+// Post posts data to every connection in connectionIdList and waits for all of them.
+func (f *Fanout) Post(ctx context.Context, connectionIdList []string, data []byte) FanoutResult {
+	var result FanoutResult
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	workers := f.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(connectionIdList) {
+		workers = len(connectionIdList)
+	}
+	queue := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for connectionId := range queue {
+				outcome, retries, err := f.postWithRetry(ctx, connectionId, data)
+				mu.Lock()
+				result.Retries += retries
+				switch outcome {
+				case postDelivered:
+					result.Delivered++
+				case postGone:
+					result.Gone = append(result.Gone, connectionId)
+				case postThrottled:
+					result.Throttled++
+				case postUnavailable:
+					result.Unavailable++
+				default:
+					result.Failed++
+				}
+				mu.Unlock()
+				if outcome != postDelivered && outcome != postGone {
+					log.Println(connectionId, err)
+				}
+			}
+		}()
+	}
+	for _, connectionId := range connectionIdList {
+		queue <- connectionId
+	}
+	close(queue)
+	wg.Wait()
+	return result
+}
+
+// postWithRetry posts until it is delivered, fails for good or runs out of attempts.
+// It returns the outcome of the last attempt and how many times it was retried.
+func (f *Fanout) postWithRetry(ctx context.Context, connectionId string, data []byte)(int, int, error) {
+	backoff := f.Backoff
+	retries := 0
+	for {
+		err := f.post(ctx, connectionId, data)
+		outcome := classifyPostError(err)
+		if outcome != postThrottled && outcome != postUnavailable {
+			return outcome, retries, err
+		}
+		if retries + 1 >= f.Attempts {
+			return outcome, retries, err
+		}
+		// Full jitter keeps the retries of a broadcast from arriving together.
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return outcome, retries, err
+		}
+		backoff *= 2
+		retries++
+	}
+}
+
+func (f *Fanout) post(ctx context.Context, connectionId string, data []byte) error {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+	// Fanout retries the posts itself, knowing which errors are worth it,
+	// so the retries of the SDK are turned off for these posts only.
+	_, err := f.Api.PostToConnection(ctx, &apigatewaymanagementapi.PostToConnectionInput{
+		Data:         data,
+		ConnectionId: &connectionId,
+	}, func(o *apigatewaymanagementapi.Options) {
+		o.RetryMaxAttempts = 1
+	})
+	return err
+}
+
+// IsGone reports whether err of the API Gateway Management API means that the
+// connection is closed: a GoneException, or its status 410.
+func IsGone(err error) bool {
+	var gone *types.GoneException
+	if errors.As(err, &gone) {
+		return true
+	}
+	var apiError interface{ ErrorCode() string }
+	if errors.As(err, &apiError) && apiError.ErrorCode() == "GoneException" {
+		return true
+	}
+	var responseError interface{ HTTPStatusCode() int }
+	return errors.As(err, &responseError) && responseError.HTTPStatusCode() == http.StatusGone
+}
+
+// classifyPostError tells what an error of PostToConnection means for the connection.
+// Only GoneException (410) means it is closed. Throttling and server errors may pass,
+// and so may a failure to reach API Gateway, but a post that timed out is not tried
+// again: the client is slow rather than the service.
+func classifyPostError(err error) int {
+	if err == nil {
+		return postDelivered
+	}
+	if IsGone(err) {
+		return postGone
+	}
+	var limitExceeded *types.LimitExceededException
+	if errors.As(err, &limitExceeded) {
+		return postThrottled
+	}
+	var apiError interface{ ErrorCode() string }
+	if errors.As(err, &apiError) {
+		switch apiError.ErrorCode() {
+		case "LimitExceededException", "TooManyRequestsException", "ThrottlingException":
+			return postThrottled
+		}
+	}
+	var responseError interface{ HTTPStatusCode() int }
+	if errors.As(err, &responseError) {
+		switch status := responseError.HTTPStatusCode(); {
+		case status == http.StatusTooManyRequests:
+			return postThrottled
+		case status >= 500:
+			return postUnavailable
+		}
+		return postFailed
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return postFailed
+	}
+	return postUnavailable
+}
@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"fmt"
+	"time"
+	"context"
+	"testing"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi/types"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat/chattest"
+)
+
+// statusError is an error with an HTTP status, like the response errors of the SDK.
+type statusError struct {
+	status int
+}
+
+func (e statusError) Error() string {
+	return http.StatusText(e.status)
+}
+
+func (e statusError) HTTPStatusCode() int {
+	return e.status
+}
+
+func connectionIds(prefix string, n int) []string {
+	var connectionIdList []string
+	for i := 0; i < n; i++ {
+		connectionIdList = append(connectionIdList, fmt.Sprintf("%s%d", prefix, i))
+	}
+	return connectionIdList
+}
+
+func TestFanoutResult(t *testing.T) {
+	api := &chattest.API{Errs: map[string]error{}}
+	var connectionIdList []string
+	connectionIdList = append(connectionIdList, connectionIds("ok", 10)...)
+	for _, connectionId := range connectionIds("gone", 3) {
+		api.Errs[connectionId] = &types.GoneException{}
+	}
+	for _, connectionId := range connectionIds("throttled", 2) {
+		api.Errs[connectionId] = &types.LimitExceededException{}
+	}
+	for _, connectionId := range connectionIds("unavailable", 2) {
+		api.Errs[connectionId] = statusError{status: http.StatusServiceUnavailable}
+	}
+	api.Errs["forbidden"] = statusError{status: http.StatusForbidden}
+	for connectionId := range api.Errs {
+		connectionIdList = append(connectionIdList, connectionId)
+	}
+	f := NewFanout(api)
+	f.Backoff = time.Millisecond
+
+	result := f.Post(context.Background(), connectionIdList, []byte("data"))
+	if result.Delivered != 10 || len(result.Gone) != 3 || result.Throttled != 2 || result.Unavailable != 2 || result.Failed != 1 {
+		t.Errorf("result = %+v, want 10 delivered, 3 gone, 2 throttled, 2 unavailable and 1 failed", result)
+	}
+	// The throttled and unavailable posts are tried Attempts times, the others once.
+	if want := 4 * (f.Attempts - 1); result.Retries != want {
+		t.Errorf("Retries = %d, want %d", result.Retries, want)
+	}
+	if want := len(connectionIdList) + 4 * (f.Attempts - 1); api.PostCount() != want {
+		t.Errorf("%d posts, want %d", api.PostCount(), want)
+	}
+	if api.RetryMaxAttempts() != 1 {
+		t.Errorf("RetryMaxAttempts = %d, want 1 so that the SDK does not retry too", api.RetryMaxAttempts())
+	}
+}
+
+func TestFanoutWorkers(t *testing.T) {
+	api := &chattest.API{Latency: 5 * time.Millisecond}
+	f := NewFanout(api)
+	f.Workers = 4
+
+	result := f.Post(context.Background(), connectionIds("c", 50), []byte("data"))
+	if result.Delivered != 50 {
+		t.Errorf("Delivered = %d, want 50", result.Delivered)
+	}
+	if api.MaxInFlight() > f.Workers {
+		t.Errorf("%d posts were in flight, want at most %d", api.MaxInFlight(), f.Workers)
+	}
+}
+
+func TestFanoutTimeout(t *testing.T) {
+	api := &chattest.API{
+		Latency: time.Millisecond,
+		Slow:    map[string]time.Duration{"slow": 10 * time.Second},
+	}
+	f := NewFanout(api)
+	f.Workers = 4
+	f.Timeout = 100 * time.Millisecond
+	connectionIdList := append([]string{"slow"}, connectionIds("c", 40)...)
+
+	start := time.Now()
+	result := f.Post(context.Background(), connectionIdList, []byte("data"))
+	elapsed := time.Since(start)
+	if result.Delivered != 40 || result.Failed != 1 || result.Retries != 0 {
+		t.Errorf("result = %+v, want 40 delivered and 1 failed without retries", result)
+	}
+	// The other workers deliver the rest while one waits for the slow connection.
+	if elapsed > 5 * f.Timeout {
+		t.Errorf("Post took %v, want about %v", elapsed, f.Timeout)
+	}
+}
+
+func BenchmarkFanout(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("connections=%d", n), func(b *testing.B) {
+			api := &chattest.API{Latency: 5 * time.Millisecond}
+			f := NewFanout(api)
+			connectionIdList := connectionIds("c", n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f.Post(context.Background(), connectionIdList, []byte("data"))
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"os"
+	"context"
+	"encoding/json"
+	"path/filepath"
+)
+
+// FileStore is a MemoryStore that writes its contents to a JSON file after every change,
+// except MarkTyping and TakeToken, which only matter for a few seconds.
+type FileStore struct {
+	*MemoryStore
+	path string
+}
+
+// fileData is the layout of the file. Seqs holds the last sequence number of each room.
+type fileData struct {
+	Seqs        map[string]int `json:"seqs"`
+	Connections []Connection   `json:"connections"`
+	Messages    []MessageData  `json:"messages"`
+}
+
+func NewFileStore(path string, limitMessageCount int)(*FileStore, error) {
+	s := &FileStore{
+		MemoryStore: NewMemoryStore(limitMessageCount),
+		path:        path,
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var d fileData
+	if err = json.Unmarshal(b, &d); err != nil {
+		return nil, err
+	}
+	for room, seq := range d.Seqs {
+		s.seqs[room] = seq
+	}
+	for _, item := range d.Connections {
+		s.connections[item.ConnectionId] = item
+	}
+	for _, item := range d.Messages {
+		s.messages[item.Id] = item
+	}
+	return s, nil
+}
+
+func (s *FileStore) PutConnection(ctx context.Context, item Connection) error {
+	if err := s.MemoryStore.PutConnection(ctx, item); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileStore) DeleteConnection(ctx context.Context, connectionId string) error {
+	if err := s.MemoryStore.DeleteConnection(ctx, connectionId); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileStore) SaveMessage(ctx context.Context, item MessageData)(MessageData, error) {
+	item, err := s.MemoryStore.SaveMessage(ctx, item)
+	if err != nil {
+		return item, err
+	}
+	return item, s.save()
+}
+
+func (s *FileStore) UpdateMessage(ctx context.Context, item MessageData) error {
+	if err := s.MemoryStore.UpdateMessage(ctx, item); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileStore) UpdateReaction(ctx context.Context, room string, seq int, emoji string, user string, add bool)(int, error) {
+	count, err := s.MemoryStore.UpdateReaction(ctx, room, seq, emoji, user, add)
+	if err != nil {
+		return count, err
+	}
+	return count, s.save()
+}
+
+func (s *FileStore) UpdateReplies(ctx context.Context, room string, seq int, delta int)(int, error) {
+	count, err := s.MemoryStore.UpdateReplies(ctx, room, seq, delta)
+	if err != nil {
+		return count, err
+	}
+	return count, s.save()
+}
+
+// save writes to a temporary file first, so a crash never leaves a broken file behind.
+// The lock is held until the rename, so the last save always holds the latest contents.
+func (s *FileStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := fileData{Seqs: s.seqs}
+	for _, item := range s.connections {
+		d.Connections = append(d.Connections, item)
+	}
+	d.Messages = s.sortedMessages()
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path) + ".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"strings"
+	"net/netip"
+)
+
+// IpFilter decides from which addresses connections are accepted.
+// An address in a denied prefix is rejected. If any prefix is allowed,
+// only the addresses in them are accepted.
+type IpFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// ParseIpFilter reads comma separated lists of CIDR prefixes or single addresses.
+func ParseIpFilter(allow string, deny string)(IpFilter, error) {
+	var f IpFilter
+	var err error
+	if f.allow, err = parsePrefixList(allow); err != nil {
+		return f, err
+	}
+	if f.deny, err = parsePrefixList(deny); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func parsePrefixList(s string)([]netip.Prefix, error) {
+	var prefixList []netip.Prefix
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixList = append(prefixList, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixList = append(prefixList, prefix.Masked())
+	}
+	return prefixList, nil
+}
+
+// Allowed reports whether a connection from ip is accepted.
+// An address that can not be parsed is only accepted when nothing is configured.
+func (f IpFilter) Allowed(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return len(f.allow) == 0 && len(f.deny) == 0
+	}
+	addr = addr.Unmap()
+	for _, p := range f.deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, p := range f.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package chat
+
+import "testing"
+
+func TestIpFilter(t *testing.T) {
+	tests := []struct {
+		allow string
+		deny  string
+		ip    string
+		want  bool
+	}{
+		{"", "", "203.0.113.5", true},
+		{"", "", "not an address", true},
+		{"", "203.0.113.0/24", "203.0.113.5", false},
+		{"", "203.0.113.0/24", "198.51.100.1", true},
+		{"", "203.0.113.5", "203.0.113.5", false},
+		{"", "203.0.113.5", "203.0.113.6", true},
+		{"10.0.0.0/8, 192.168.1.1", "", "10.1.2.3", true},
+		{"10.0.0.0/8, 192.168.1.1", "", "192.168.1.1", true},
+		{"10.0.0.0/8, 192.168.1.1", "", "192.168.1.2", false},
+		{"10.0.0.0/8", "10.0.0.0/16", "10.0.1.1", false},
+		{"10.0.0.0/8", "10.0.0.0/16", "10.1.0.1", true},
+		{"10.0.0.0/8", "", "not an address", false},
+		{"", "10.0.0.0/8", "::ffff:10.0.0.1", false},
+		{"2001:db8::/32", "", "2001:db8::1", true},
+		{"2001:db8::/32", "", "2001:db9::1", false},
+		{"10.0.0.1/8", "", "10.200.0.1", true},
+	}
+	for _, tt := range tests {
+		f, err := ParseIpFilter(tt.allow, tt.deny)
+		if err != nil {
+			t.Fatalf("ParseIpFilter(%q, %q): %v", tt.allow, tt.deny, err)
+		}
+		if got := f.Allowed(tt.ip); got != tt.want {
+			t.Errorf("allow %q deny %q: Allowed(%q) = %v, want %v", tt.allow, tt.deny, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestParseIpFilterErrors(t *testing.T) {
+	for _, s := range []string{"10.0.0.0/33", "10.0.0", "example.com"} {
+		if _, err := ParseIpFilter(s, ""); err == nil {
+			t.Errorf("ParseIpFilter(%q) accepted it", s)
+		}
+		if _, err := ParseIpFilter("", s); err == nil {
+			t.Errorf("ParseIpFilter deny %q accepted it", s)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package chat
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"context"
+)
+
+// MemoryStore keeps connections and messages in process memory.
+// It follows the same rules as DynamoDBStore, so it can be used for local development.
+type MemoryStore struct {
+	mu                sync.Mutex
+	connections       map[string]Connection
+	messages          map[string]MessageData
+	buckets           map[string]Bucket
+	seqs              map[string]int
+	limitMessageCount int
+}
+
+func NewMemoryStore(limitMessageCount int) *MemoryStore {
+	return &MemoryStore{
+		connections:       map[string]Connection{},
+		messages:          map[string]MessageData{},
+		buckets:           map[string]Bucket{},
+		seqs:              map[string]int{},
+		limitMessageCount: limitMessageCount,
+	}
+}
+
+func (s *MemoryStore) GetConnectionCount(ctx context.Context)(int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.connections), nil
+}
+
+func (s *MemoryStore) GetConnection(ctx context.Context, connectionId string)(Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.connections[connectionId]
+	if !ok {
+		return item, ErrNotFound
+	}
+	return item, nil
+}
+
+func (s *MemoryStore) ListConnections(ctx context.Context)([]Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var connectionList []Connection
+	for _, item := range s.connections {
+		connectionList = append(connectionList, item)
+	}
+	return connectionList, nil
+}
+
+func (s *MemoryStore) ListRoomConnections(ctx context.Context, room string)([]Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var connectionList []Connection
+	for _, item := range s.connections {
+		if item.Room == room {
+			connectionList = append(connectionList, item)
+		}
+	}
+	return connectionList, nil
+}
+
+func (s *MemoryStore) ListUserConnections(ctx context.Context, userId string)([]Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var connectionList []Connection
+	for _, item := range s.connections {
+		if item.UserId == userId {
+			connectionList = append(connectionList, item)
+		}
+	}
+	return connectionList, nil
+}
+
+func (s *MemoryStore) CountIpConnections(ctx context.Context, sourceIp string)(int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, item := range s.connections {
+		if item.SourceIp == sourceIp {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) PutConnection(ctx context.Context, item Connection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connections[item.ConnectionId] = item
+	return nil
+}
+
+func (s *MemoryStore) DeleteConnection(ctx context.Context, connectionId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.connections, connectionId)
+	return nil
+}
+
+func (s *MemoryStore) MarkTyping(ctx context.Context, connectionId string, now time.Time, interval time.Duration)(bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.connections[connectionId]
+	if !ok || (item.Typing > 0 && now.UnixMilli() - item.Typing < interval.Milliseconds()) {
+		return false, nil
+	}
+	item.Typing = now.UnixMilli()
+	s.connections[connectionId] = item
+	return true, nil
+}
+
+func (s *MemoryStore) TakeToken(ctx context.Context, key string, limit RateLimit, now time.Time)(bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket := s.buckets[key]
+	bucket.Key = key
+	if !bucket.Take(limit, now) {
+		return false, nil
+	}
+	s.buckets[key] = bucket
+	return true, nil
+}
+
+func (s *MemoryStore) ListMessages(ctx context.Context, query MessageQuery)([]MessageData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room := query.Room
+	if room == "" {
+		room = DefaultRoom
+	}
+	var messageList []MessageData
+	for _, item := range s.sortedMessages() {
+		if item.Room != room || (query.Before > 0 && item.Seq >= query.Before) || item.Seq <= query.Since {
+			continue
+		}
+		if query.ParentId > 0 && item.ParentId != query.ParentId {
+			continue
+		}
+		messageList = append(messageList, item)
+	}
+	if query.Limit > 0 && len(messageList) > query.Limit {
+		if query.Oldest {
+			messageList = messageList[:query.Limit]
+		} else {
+			messageList = messageList[len(messageList) - query.Limit:]
+		}
+	}
+	return messageList, nil
+}
+
+func (s *MemoryStore) SaveMessage(ctx context.Context, item MessageData)(MessageData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item.Room == "" {
+		item.Room = DefaultRoom
+	}
+	s.seqs[item.Room]++
+	item.Seq = s.seqs[item.Room]
+	item.Id = MessageId(item.Room, item.Seq, s.limitMessageCount)
+	s.messages[item.Id] = item
+	return item, nil
+}
+
+func (s *MemoryStore) FindClientMessage(ctx context.Context, room string, clientId string)(MessageData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range s.messages {
+		if (room == "" || item.Room == room) && item.ClientId == clientId {
+			return item, nil
+		}
+	}
+	return MessageData{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetMessage(ctx context.Context, room string, seq int)(MessageData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.messages[MessageId(room, seq, s.limitMessageCount)]
+	if !ok || item.Seq != seq {
+		return MessageData{}, ErrNotFound
+	}
+	return item, nil
+}
+
+func (s *MemoryStore) UpdateMessage(ctx context.Context, item MessageData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := MessageId(item.Room, item.Seq, s.limitMessageCount)
+	current, ok := s.messages[id]
+	if !ok || current.Seq != item.Seq {
+		return ErrNotFound
+	}
+	current.Data = item.Data
+	current.Edited = item.Edited
+	current.Deleted = item.Deleted
+	s.messages[id] = current
+	return nil
+}
+
+// UpdateReaction replaces the map instead of changing it, because copies of
+// the message returned earlier share it.
+func (s *MemoryStore) UpdateReaction(ctx context.Context, room string, seq int, emoji string, user string, add bool)(int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := MessageId(room, seq, s.limitMessageCount)
+	item, ok := s.messages[id]
+	if !ok || item.Seq != seq {
+		return 0, ErrNotFound
+	}
+	reactions := map[string][]string{}
+	for k, v := range item.Reactions {
+		reactions[k] = v
+	}
+	var users []string
+	for _, u := range reactions[emoji] {
+		if u != user {
+			users = append(users, u)
+		}
+	}
+	if add {
+		users = append(users, user)
+	}
+	if len(users) > 0 {
+		reactions[emoji] = users
+	} else {
+		delete(reactions, emoji)
+	}
+	item.Reactions = reactions
+	s.messages[id] = item
+	return len(users), nil
+}
+
+func (s *MemoryStore) UpdateReplies(ctx context.Context, room string, seq int, delta int)(int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := MessageId(room, seq, s.limitMessageCount)
+	item, ok := s.messages[id]
+	if !ok || item.Seq != seq {
+		return 0, ErrNotFound
+	}
+	item.Replies += delta
+	s.messages[id] = item
+	return item.Replies, nil
+}
+
+func (s *MemoryStore) sortedMessages() []MessageData {
+	var messageList []MessageData
+	for _, item := range s.messages {
+		messageList = append(messageList, item)
+	}
+	sort.Slice(messageList, func(i, j int) bool {
+		if messageList[i].Room != messageList[j].Room {
+			return messageList[i].Room < messageList[j].Room
+		}
+		return messageList[i].Seq < messageList[j].Seq
+	})
+	return messageList
+}
@@ -0,0 +1,163 @@
+package chat
+
+import (
+	"sync"
+	"errors"
+	"context"
+	"testing"
+	"path/filepath"
+)
+
+// saveParallel saves n messages from n goroutines and checks that none was lost.
+func saveParallel(t *testing.T, store MessageStore, n int) {
+	ctx := context.Background()
+	seqList := make([]int, n)
+	errList := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			saved, err := store.SaveMessage(ctx, MessageData{Data: "message", Created: int64(i + 1)})
+			seqList[i], errList[i] = saved.Seq, err
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[int]bool{}
+	for i, seq := range seqList {
+		if errList[i] != nil {
+			t.Fatalf("SaveMessage: %v", errList[i])
+		}
+		if seq < 1 || seq > n {
+			t.Errorf("seq %d is out of 1..%d", seq, n)
+		}
+		if seen[seq] {
+			t.Errorf("seq %d was returned twice", seq)
+		}
+		seen[seq] = true
+	}
+	messageList, err := store.ListMessages(ctx, MessageQuery{})
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	if len(messageList) != n {
+		t.Errorf("ListMessages returned %d messages, want %d", len(messageList), n)
+	}
+	for _, item := range messageList {
+		if !seen[item.Seq] {
+			t.Errorf("ListMessages returned seq %d that SaveMessage did not", item.Seq)
+		}
+	}
+}
+
+func TestMemoryStoreSaveMessageParallel(t *testing.T) {
+	const n = 100
+	saveParallel(t, NewMemoryStore(n), n)
+}
+
+func TestFileStoreSaveMessageParallel(t *testing.T) {
+	const n = 100
+	path := filepath.Join(t.TempDir(), "chat.json")
+	store, err := NewFileStore(path, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saveParallel(t, store, n)
+
+	// The file has every message once the saves returned.
+	store, err = NewFileStore(path, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageList, err := store.ListMessages(context.Background(), MessageQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messageList) != n {
+		t.Errorf("reloaded store has %d messages, want %d", len(messageList), n)
+	}
+}
+
+func TestMemoryStoreRingBuffer(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(3)
+	for i := 1; i <= 5; i++ {
+		if _, err := store.SaveMessage(ctx, MessageData{Data: "message", Created: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, seq := range []int{1, 2} {
+		if _, err := store.GetMessage(ctx, DefaultRoom, seq); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMessage(%d) = %v, want ErrNotFound", seq, err)
+		}
+	}
+	for _, seq := range []int{3, 4, 5} {
+		item, err := store.GetMessage(ctx, DefaultRoom, seq)
+		if err != nil || item.Seq != seq {
+			t.Errorf("GetMessage(%d) = seq %d, %v", seq, item.Seq, err)
+		}
+	}
+	messageList, _ := store.ListMessages(ctx, MessageQuery{})
+	if len(messageList) != 3 || messageList[0].Seq != 3 {
+		t.Errorf("ListMessages returned %v, want seq 3 to 5", messageList)
+	}
+}
+
+// Paging on seq reaches every message, even those created in the same millisecond.
+func TestMemoryStoreListMessagesBefore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(0)
+	for i := 0; i < 5; i++ {
+		if _, err := store.SaveMessage(ctx, MessageData{Data: "message", Created: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var seqList []int
+	before := 0
+	for page := 0; page < 5; page++ {
+		messageList, err := store.ListMessages(ctx, MessageQuery{Before: before, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messageList) == 0 {
+			break
+		}
+		for i := len(messageList) - 1; i >= 0; i-- {
+			seqList = append(seqList, messageList[i].Seq)
+		}
+		before = messageList[0].Seq
+	}
+	if len(seqList) != 5 || seqList[0] != 5 || seqList[4] != 1 {
+		t.Errorf("pages returned seq %v, want 5 to 1", seqList)
+	}
+}
+
+// Each room has its own ring buffer, so a busy room never overwrites the messages of a quiet one.
+func TestMemoryStoreRoomsKeepTheirMessages(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(3)
+	quiet, err := store.SaveMessage(ctx, MessageData{Room: "quiet", Data: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err = store.SaveMessage(ctx, MessageData{Room: "busy", Data: "message"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if quiet.Seq != 1 {
+		t.Errorf("first message of quiet has seq %d, want 1", quiet.Seq)
+	}
+	if item, err := store.GetMessage(ctx, "quiet", quiet.Seq); err != nil || item.Data != "hello" {
+		t.Errorf("GetMessage(quiet, %d) = %+v, %v", quiet.Seq, item, err)
+	}
+	messageList, _ := store.ListMessages(ctx, MessageQuery{Room: "quiet"})
+	if len(messageList) != 1 {
+		t.Errorf("quiet has %d messages, want 1", len(messageList))
+	}
+	messageList, _ = store.ListMessages(ctx, MessageQuery{Room: "busy"})
+	if len(messageList) != 3 || messageList[0].Seq != 8 {
+		t.Errorf("busy has %v, want seq 8 to 10", messageList)
+	}
+}
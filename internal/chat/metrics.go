@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"encoding/json"
+)
+
+// PutMetrics writes counts in the CloudWatch embedded metric format, which Lambda
+// turns into metrics of METRICS_NAMESPACE from the function log. Nothing is written
+// when it is not set.
+func PutMetrics(metrics map[string]int) {
+	namespace := os.Getenv("METRICS_NAMESPACE")
+	if namespace == "" || len(metrics) == 0 {
+		return
+	}
+	var definitions []map[string]string
+	record := map[string]interface{}{}
+	for name, value := range metrics {
+		definitions = append(definitions, map[string]string{"Name": name, "Unit": "Count"})
+		record[name] = value
+	}
+	record["_aws"] = map[string]interface{}{
+		"Timestamp": time.Now().UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  namespace,
+			"Dimensions": [][]string{{}},
+			"Metrics":    definitions,
+		}},
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	// The log package would prefix the record with a timestamp.
+	fmt.Fprintln(os.Stdout, string(b))
+}
+
+// Metrics returns the counts of r to be put with PutMetrics.
+func (r FanoutResult) Metrics() map[string]int {
+	return map[string]int{
+		"PostDelivered":   r.Delivered,
+		"PostGone":        len(r.Gone),
+		"PostThrottled":   r.Throttled,
+		"PostUnavailable": r.Unavailable,
+		"PostFailed":      r.Failed,
+		"PostRetries":     r.Retries,
+	}
+}
@@ -0,0 +1,154 @@
+package chat
+
+import (
+	"os"
+	"log"
+	"sync"
+	"errors"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// BroadcastJob asks the broadcast worker to post Data to the connections in Room
+// except Skip. DomainName and Stage are those of the WebSocket API the frame came
+// to, as the worker is not invoked by it.
+type BroadcastJob struct {
+	Room       string `json:"room"`
+	Data       []byte `json:"data"`
+	Skip       string `json:"skip,omitempty"`
+	DomainName string `json:"domainName"`
+	Stage      string `json:"stage"`
+}
+
+// RequestContext returns the request context from which DefaultConnectionAPI
+// reaches the WebSocket API of j.
+func (j BroadcastJob) RequestContext() events.APIGatewayWebsocketProxyRequestContext {
+	return events.APIGatewayWebsocketProxyRequestContext{
+		DomainName: j.DomainName,
+		Stage:      j.Stage,
+	}
+}
+
+// BroadcastQueue hands broadcast jobs over to the broadcast worker.
+type BroadcastQueue interface {
+	Enqueue(ctx context.Context, job BroadcastJob) error
+}
+
+var ErrQueueFull = errors.New("broadcast queue is full")
+
+var defaultBroadcastQueue BroadcastQueue
+var defaultBroadcastQueueMu sync.Mutex
+
+// DefaultBroadcastQueue returns the BroadcastQueue shared by the handlers in this process.
+// Unless SetDefaultBroadcastQueue was called, it is the SQS queue at BROADCAST_QUEUE_URL,
+// or nil if that is not set.
+func DefaultBroadcastQueue(cfg aws.Config) BroadcastQueue {
+	defaultBroadcastQueueMu.Lock()
+	defer defaultBroadcastQueueMu.Unlock()
+	if defaultBroadcastQueue == nil {
+		if queueUrl := os.Getenv("BROADCAST_QUEUE_URL"); queueUrl != "" {
+			defaultBroadcastQueue = NewSQSQueue(cfg, queueUrl)
+		}
+	}
+	return defaultBroadcastQueue
+}
+
+func SetDefaultBroadcastQueue(q BroadcastQueue) {
+	defaultBroadcastQueueMu.Lock()
+	defer defaultBroadcastQueueMu.Unlock()
+	defaultBroadcastQueue = q
+}
+
+// SQSQueue sends broadcast jobs as JSON messages to an SQS FIFO queue. The jobs of
+// a room form a message group, so the worker posts them in the order they were sent.
+type SQSQueue struct {
+	client   *sqs.Client
+	queueUrl string
+}
+
+func NewSQSQueue(cfg aws.Config, queueUrl string) *SQSQueue {
+	return &SQSQueue{
+		client:   sqs.NewFromConfig(cfg),
+		queueUrl: queueUrl,
+	}
+}
+
+func (q *SQSQueue) Enqueue(ctx context.Context, job BroadcastJob) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	// Every job is new, so none is dropped as a duplicate of another with the same frame.
+	deduplicationId := make([]byte, 16)
+	if _, err = rand.Read(deduplicationId); err != nil {
+		return err
+	}
+	_, err = q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(q.queueUrl),
+		MessageBody:            aws.String(string(b)),
+		MessageGroupId:         aws.String(job.Room),
+		MessageDeduplicationId: aws.String(hex.EncodeToString(deduplicationId)),
+	})
+	return err
+}
+
+// LocalQueue is a BroadcastQueue in this process standing in for SQS.
+// Run hands the jobs to a worker in batches, like the event source of the worker Lambda.
+type LocalQueue struct {
+	jobs      chan BroadcastJob
+	batchSize int
+}
+
+// NewLocalQueue returns a queue holding up to size jobs, handed over batchSize at a time.
+func NewLocalQueue(size int, batchSize int) *LocalQueue {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &LocalQueue{
+		jobs:      make(chan BroadcastJob, size),
+		batchSize: batchSize,
+	}
+}
+
+// Enqueue returns ErrQueueFull instead of waiting when the queue is full.
+func (q *LocalQueue) Enqueue(ctx context.Context, job BroadcastJob) error {
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run passes the queued jobs to worker until ctx is done. A batch holds the jobs
+// that are waiting when the first of them arrives.
+func (q *LocalQueue) Run(ctx context.Context, worker func(ctx context.Context, jobs []BroadcastJob) error) {
+	for {
+		var batch []BroadcastJob
+		select {
+		case job := <-q.jobs:
+			batch = append(batch, job)
+		case <-ctx.Done():
+			return
+		}
+	fill:
+		for len(batch) < q.batchSize {
+			select {
+			case job := <-q.jobs:
+				batch = append(batch, job)
+			default:
+				break fill
+			}
+		}
+		// Like SQS, a failed batch would be received again; locally it is only logged.
+		if err := worker(ctx, batch); err != nil {
+			log.Print(err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"os"
+	"math"
+	"time"
+	"strconv"
+)
+
+// RateLimit is a token bucket holding up to Burst tokens, refilled at Rate tokens
+// per second. Each frame takes a token. A Rate of 0 disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// Bucket is the state of the token bucket of Key as of Updated, in Unix milliseconds.
+// Expires, in Unix seconds, is when it would be full again, so it can be dropped.
+type Bucket struct {
+	Key     string  `dynamodbav:"key" json:"key"`
+	Tokens  float64 `dynamodbav:"tokens" json:"tokens"`
+	Updated int64   `dynamodbav:"updated" json:"updated"`
+	Expires int64   `dynamodbav:"expires" json:"expires"`
+}
+
+// RateLimitFromEnv reads a RateLimit from the environment variables rateKey and burstKey.
+// The burst defaults to one second of the rate, and at least one token.
+func RateLimitFromEnv(rateKey string, burstKey string) RateLimit {
+	rate, _ := strconv.ParseFloat(os.Getenv(rateKey), 64)
+	burst, _ := strconv.ParseFloat(os.Getenv(burstKey), 64)
+	if rate < 0 {
+		rate = 0
+	}
+	if burst < 1 {
+		burst = math.Max(rate, 1)
+	}
+	return RateLimit{Rate: rate, Burst: burst}
+}
+
+// Enabled reports whether l limits anything.
+func (l RateLimit) Enabled() bool {
+	return l.Rate > 0
+}
+
+// Take refills b for the time since it was updated and takes a token at now.
+// It reports whether there was one; if not, b is left as it was.
+func (b *Bucket) Take(limit RateLimit, now time.Time) bool {
+	tokens := limit.Burst
+	if b.Updated > 0 {
+		elapsed := float64(now.UnixMilli() - b.Updated) / 1000
+		tokens = math.Min(limit.Burst, b.Tokens + math.Max(elapsed, 0) * limit.Rate)
+	}
+	if tokens < 1 {
+		return false
+	}
+	b.Tokens = tokens - 1
+	b.Updated = now.UnixMilli()
+	b.Expires = now.Add(time.Duration((limit.Burst - b.Tokens) / limit.Rate * float64(time.Second))).Unix() + 1
+	return true
+}
@@ -0,0 +1,74 @@
+package chat
+
+import (
+	"time"
+	"testing"
+)
+
+func TestBucketTake(t *testing.T) {
+	limit := RateLimit{Rate: 2, Burst: 3}
+	now := time.Unix(1700000000, 0)
+	var b Bucket
+
+	// A new bucket starts full.
+	for i := 0; i < 3; i++ {
+		if !b.Take(limit, now) {
+			t.Fatalf("take %d of a full bucket failed", i + 1)
+		}
+	}
+	if b.Take(limit, now) {
+		t.Fatal("took a token from an empty bucket")
+	}
+	if b.Tokens != 0 || b.Updated != now.UnixMilli() {
+		t.Errorf("failed take changed the bucket to %+v", b)
+	}
+
+	// Half a second at 2 per second refills one token.
+	now = now.Add(500 * time.Millisecond)
+	if !b.Take(limit, now) {
+		t.Fatal("no token after refilling for half a second")
+	}
+	if b.Take(limit, now) {
+		t.Fatal("took a second token after refilling only one")
+	}
+
+	// The refill stops at the burst.
+	now = now.Add(time.Minute)
+	if !b.Take(limit, now) || b.Tokens != 2 {
+		t.Errorf("after a minute the bucket has %v tokens left, want 2", b.Tokens)
+	}
+	// Expires is when the taken token is back, rounded up to the next second.
+	if want := now.Add(500 * time.Millisecond).Unix() + 1; b.Expires != want {
+		t.Errorf("expires = %d, want %d", b.Expires, want)
+	}
+}
+
+func TestBucketTakeClockSkew(t *testing.T) {
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Unix(1700000000, 0)
+	b := Bucket{Tokens: 0, Updated: now.UnixMilli()}
+	if b.Take(limit, now.Add(-time.Minute)) {
+		t.Error("a clock going back refilled the bucket")
+	}
+}
+
+func TestRateLimitFromEnv(t *testing.T) {
+	tests := []struct {
+		rate  string
+		burst string
+		want  RateLimit
+	}{
+		{"", "", RateLimit{0, 1}},
+		{"5", "", RateLimit{5, 5}},
+		{"0.5", "", RateLimit{0.5, 1}},
+		{"5", "20", RateLimit{5, 20}},
+		{"-1", "", RateLimit{0, 1}},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_RATE", tt.rate)
+		t.Setenv("TEST_BURST", tt.burst)
+		if got := RateLimitFromEnv("TEST_RATE", "TEST_BURST"); got != tt.want {
+			t.Errorf("rate %q burst %q = %+v, want %+v", tt.rate, tt.burst, got, tt.want)
+		}
+	}
+}
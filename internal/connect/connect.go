@@ -0,0 +1,213 @@
+package connect
+
+import (
+	"os"
+	"fmt"
+	"log"
+	"time"
+	"errors"
+	"context"
+	"strconv"
+	"strings"
+	"net/http"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/auth"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+type ErrorResponse struct {
+	Message string `json:"message"`
+}
+
+type Response events.APIGatewayProxyResponse
+
+func HandleRequest(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (Response, error) {
+	var err error
+	var jsonBytes []byte
+	sourceIp := request.RequestContext.Identity.SourceIP
+	ipFilter, err := chat.ParseIpFilter(os.Getenv("ALLOW_CIDRS"), os.Getenv("DENY_CIDRS"))
+	if err != nil {
+		// A broken list must not let everyone in.
+		log.Print(err)
+		jsonBytes, _ = json.Marshal(ErrorResponse{Message: "invalid address filter"})
+		return Response{
+			StatusCode: http.StatusInternalServerError,
+			Body: string(jsonBytes),
+		}, nil
+	}
+	if !ipFilter.Allowed(sourceIp) {
+		jsonBytes, _ = json.Marshal(ErrorResponse{Message: "address is not allowed"})
+		return Response{
+			StatusCode: http.StatusForbidden,
+			Body: string(jsonBytes),
+		}, nil
+	}
+	room := request.QueryStringParameters["room"]
+	if room == "" {
+		room = chat.DefaultRoom
+	} else if !chat.ValidRoom(room) {
+		jsonBytes, _ = json.Marshal(ErrorResponse{Message: "invalid room"})
+		return Response{
+			StatusCode: http.StatusBadRequest,
+			Body: string(jsonBytes),
+		}, nil
+	}
+	// A name from the authorizer is trusted over the one in the query string.
+	name := chat.AuthorizerValue(request.RequestContext.Authorizer, "name")
+	if name == "" {
+		name = strings.TrimSpace(request.QueryStringParameters["name"])
+	}
+	if name != "" && !chat.ValidName(name) {
+		jsonBytes, _ = json.Marshal(ErrorResponse{Message: "invalid name"})
+		return Response{
+			StatusCode: http.StatusBadRequest,
+			Body: string(jsonBytes),
+		}, nil
+	}
+	connectionStore := chat.DefaultStore(ctx)
+	cfg := chat.GetConfig(ctx)
+	apigatewayClient := chat.DefaultConnectionAPI(cfg, request.RequestContext)
+	userId := chat.AuthorizerValue(request.RequestContext.Authorizer, "userId")
+	if name != "" {
+		taken, err := nameTaken(ctx, connectionStore, apigatewayClient, room, name, userId)
+		if err != nil {
+			log.Print(err)
+		} else if taken {
+			jsonBytes, _ = json.Marshal(ErrorResponse{Message: "name is already used in this room"})
+			return Response{
+				StatusCode: http.StatusConflict,
+				Body: string(jsonBytes),
+			}, nil
+		}
+	}
+	if name == "" {
+		name, err = guestName(ctx, connectionStore, apigatewayClient, room)
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	if limitIpCount, _ := strconv.Atoi(os.Getenv("LIMIT_IP_CONNECTION_COUNT")); limitIpCount > 0 {
+		ipCount, err := connectionStore.CountIpConnections(ctx, sourceIp)
+		if err != nil {
+			log.Print(err)
+		} else if ipCount >= limitIpCount {
+			jsonBytes, _ = json.Marshal(ErrorResponse{Message: "too many connections from this address"})
+			return Response{
+				StatusCode: http.StatusTooManyRequests,
+				Body: string(jsonBytes),
+			}, nil
+		}
+	}
+	connectionCount, err := connectionStore.GetConnectionCount(ctx)
+	limitCount, _ := strconv.Atoi(os.Getenv("LIMIT_CONNECTION_COUNT"))
+	var connection chat.Connection
+	if err == nil && connectionCount < limitCount {
+		connection, err = putConnection(ctx, connectionStore, chat.Connection{
+			ConnectionId: request.RequestContext.ConnectionID,
+			UserId:       userId,
+			Name:         name,
+			Room:         room,
+			SourceIp:     sourceIp,
+		})
+	} else if connectionCount >= limitCount {
+		err = errors.New("too many connections")
+	}
+	log.Print(sourceIp)
+	if err != nil {
+		log.Print(err)
+		jsonBytes, _ = json.Marshal(ErrorResponse{Message: fmt.Sprint(err)})
+		return Response{
+			StatusCode: http.StatusInternalServerError,
+			Body: string(jsonBytes),
+		}, nil
+	}
+	// The new connection can not receive frames until it is accepted, so it is skipped.
+	if err = chat.Announce(ctx, cfg, connectionStore, apigatewayClient, request.RequestContext, chat.EventJoined, connection); err != nil {
+		log.Print(err)
+	}
+	responseBody := ""
+	if len(jsonBytes) > 0 {
+		responseBody = string(jsonBytes)
+	}
+	res := Response {
+		StatusCode: http.StatusOK,
+		Body: responseBody,
+	}
+	// Browsers close the socket unless one of the offered protocols is selected.
+	// Clients sending the token this way offer a protocol name first.
+	if protocols := auth.Protocols(request.Headers); len(protocols) > 0 {
+		res.Headers = map[string]string{
+			"Sec-WebSocket-Protocol": protocols[0],
+		}
+	}
+	return res, nil
+}
+
+// nameTaken reports whether someone else uses name in room. The signed-in user userId
+// may use it in every tab. A connection API Gateway reports as gone, like the one a
+// reconnecting client left behind before $disconnect ran, gives up its name.
+func nameTaken(ctx context.Context, connectionStore chat.ConnectionStore, apigatewayClient chat.ConnectionAPI, room string, name string, userId string)(bool, error) {
+	connectionList, err := connectionStore.ListRoomConnections(ctx, room)
+	if err != nil {
+		return false, err
+	}
+	for _, item := range connectionList {
+		if !strings.EqualFold(item.Name, name) || (userId != "" && item.UserId == userId) {
+			continue
+		}
+		connectionId := item.ConnectionId
+		_, err = apigatewayClient.GetConnection(ctx, &apigatewaymanagementapi.GetConnectionInput{
+			ConnectionId: &connectionId,
+		})
+		if !chat.IsGone(err) {
+			return true, nil
+		}
+		if err = connectionStore.DeleteConnection(ctx, connectionId); err != nil {
+			log.Print(err)
+		}
+	}
+	return false, nil
+}
+
+// guestNameRetry is how many guest names are tried. Each try has two more random
+// digits than the one before, so a room full of guests does not run out of names.
+const guestNameRetry int = 4
+
+// guestName returns a name like Guest 1A2B that nobody uses in room.
+func guestName(ctx context.Context, connectionStore chat.ConnectionStore, apigatewayClient chat.ConnectionAPI, room string)(string, error) {
+	var name string
+	for i := 0; i < guestNameRetry; i++ {
+		b := make([]byte, 2 + i)
+		if _, err := rand.Read(b); err != nil {
+			return name, err
+		}
+		name = "Guest " + strings.ToUpper(hex.EncodeToString(b))
+		taken, err := nameTaken(ctx, connectionStore, apigatewayClient, room, name, "")
+		if err != nil || !taken {
+			return name, err
+		}
+	}
+	return name, nil
+}
+
+func putConnection(ctx context.Context, connectionStore chat.ConnectionStore, item chat.Connection)(chat.Connection, error) {
+	t_ := chat.Timestamp(time.Now())
+	c := strconv.FormatInt(int64(t_), 16)
+	item.Created = t_
+	item.Color = "00" + c[(len(c) - 4):]
+	if item.Name == "" {
+		item.Name = "Guest " + strings.ToUpper(c[(len(c) - 4):])
+	}
+	err := connectionStore.PutConnection(ctx, item)
+	if err != nil {
+		log.Print(err)
+		return item, err
+	}
+	return item, nil
+}
@@ -0,0 +1,93 @@
+package connect
+
+import (
+	"fmt"
+	"errors"
+	"context"
+	"testing"
+	"net/http"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat/chattest"
+)
+
+func connectRequest(connectionId string, name string, userId string) events.APIGatewayWebsocketProxyRequest {
+	var request events.APIGatewayWebsocketProxyRequest
+	request.RequestContext.ConnectionID = connectionId
+	request.QueryStringParameters = map[string]string{"name": name}
+	if userId != "" {
+		request.RequestContext.Authorizer = map[string]interface{}{"userId": userId, "name": name}
+	}
+	return request
+}
+
+func TestNameTaken(t *testing.T) {
+	t.Setenv("LIMIT_CONNECTION_COUNT", "100")
+	t.Setenv("REGION", "us-east-1")
+	ctx := context.Background()
+	api := &chattest.API{}
+	chat.SetDefaultConnectionAPI(api)
+	defer chat.SetDefaultConnectionAPI(nil)
+
+	tests := []struct {
+		what         string
+		connectionId string
+		name         string
+		userId       string
+		status       int
+	}{
+		{"first tab of a user", "u1", "Alice", "alice", http.StatusOK},
+		{"second tab of the same user", "u2", "Alice", "alice", http.StatusOK},
+		{"another user with the name", "u3", "alice", "mallory", http.StatusConflict},
+		{"guest with the name", "g1", "Alice", "", http.StatusConflict},
+		{"first guest", "g2", "Bob", "", http.StatusOK},
+		{"another guest with the name", "g3", "Bob", "", http.StatusConflict},
+	}
+	chat.SetDefaultStore(chat.NewMemoryStore(0))
+	defer chat.SetDefaultStore(nil)
+	for _, tt := range tests {
+		res, err := HandleRequest(ctx, connectRequest(tt.connectionId, tt.name, tt.userId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.what, res.StatusCode, tt.status)
+		}
+	}
+
+	// The connection left behind by a reconnecting guest gives up its name.
+	api.Close("g2")
+	res, err := HandleRequest(ctx, connectRequest("g4", "Bob", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("reconnecting guest: status %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if _, err = chat.DefaultStore(ctx).GetConnection(ctx, "g2"); !errors.Is(err, chat.ErrNotFound) {
+		t.Errorf("gone connection g2 is still stored: %v", err)
+	}
+}
+
+// Guests get a longer name when every short one is taken.
+func TestGuestName(t *testing.T) {
+	ctx := context.Background()
+	store := chat.NewMemoryStore(0)
+	for i := 0; i < 0x10000; i++ {
+		id := fmt.Sprintf("%04X", i)
+		if err := store.PutConnection(ctx, chat.Connection{ConnectionId: id, Name: "Guest " + id, Room: chat.DefaultRoom}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name, err := guestName(ctx, store, &chattest.API{}, chat.DefaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(name) != len("Guest 123456") || !chat.ValidName(name) {
+		t.Errorf("name = %q, want a valid name with 6 digits", name)
+	}
+	if name, err = guestName(ctx, store, &chattest.API{}, "other"); err != nil || len(name) != len("Guest 1234") {
+		t.Errorf("name in an empty room = %q, %v, want 4 digits", name, err)
+	}
+}
@@ -0,0 +1,75 @@
+package cron
+
+import (
+	"os"
+	"log"
+	"time"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+func HandleRequest(ctx context.Context, event events.CloudWatchEvent) error {
+	// The cron is not invoked by the WebSocket API, so its endpoint comes from the environment.
+	apigatewayClient := chat.DefaultConnectionAPI(chat.GetConfig(ctx), events.APIGatewayWebsocketProxyRequestContext{
+		DomainName: os.Getenv("WEBSOCKET_DOMAIN_NAME"),
+		Stage:      os.Getenv("WEBSOCKET_STAGE"),
+	})
+	idleTimeout, err := time.ParseDuration(os.Getenv("IDLE_TIMEOUT"))
+	if err != nil && os.Getenv("IDLE_TIMEOUT") != "" {
+		log.Print(err)
+	}
+	err = checkConnections(ctx, chat.DefaultStore(ctx), apigatewayClient, idleTimeout, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkConnections asks API Gateway about every stored connection. Those it reports
+// as gone are deleted, and those idle for longer than idleTimeout, if it is set, are
+// closed, which runs $disconnect for them. A connection is kept when the answer is an
+// other error, so that a failure of API Gateway does not drop everyone.
+func checkConnections(ctx context.Context, connectionStore chat.ConnectionStore, apigatewayClient chat.ConnectionAPI, idleTimeout time.Duration, now time.Time) error {
+	connectionList, err := connectionStore.ListConnections(ctx)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	for _, item := range connectionList {
+		connectionId := item.ConnectionId
+		res, err := apigatewayClient.GetConnection(ctx, &apigatewaymanagementapi.GetConnectionInput{
+			ConnectionId: &connectionId,
+		})
+		if chat.IsGone(err) {
+			deleteConnection(ctx, connectionStore, connectionId)
+			continue
+		} else if err != nil {
+			log.Print(err)
+			continue
+		}
+		if idleTimeout <= 0 || res.LastActiveAt == nil || now.Sub(aws.ToTime(res.LastActiveAt)) <= idleTimeout {
+			continue
+		}
+		_, err = apigatewayClient.DeleteConnection(ctx, &apigatewaymanagementapi.DeleteConnectionInput{
+			ConnectionId: &connectionId,
+		})
+		if chat.IsGone(err) {
+			deleteConnection(ctx, connectionStore, connectionId)
+		} else if err != nil {
+			log.Print(err)
+		}
+	}
+	return nil
+}
+
+func deleteConnection(ctx context.Context, connectionStore chat.ConnectionStore, connectionId string) {
+	err := connectionStore.DeleteConnection(ctx, connectionId)
+	if err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,67 @@
+package cron
+
+import (
+	"time"
+	"errors"
+	"context"
+	"testing"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat/chattest"
+)
+
+func TestCheckConnections(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := chat.NewMemoryStore(0)
+	for _, id := range []string{"gone", "idle", "active", "unknown", "failing"} {
+		if err := store.PutConnection(ctx, chat.Connection{ConnectionId: id, Room: chat.DefaultRoom}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &chattest.API{
+		Errs: map[string]error{"failing": errors.New("internal error")},
+		LastActive: map[string]time.Time{
+			"idle":    now.Add(-time.Hour),
+			"active":  now.Add(-time.Minute),
+			"failing": now.Add(-time.Hour),
+		},
+	}
+	api.Close("gone")
+
+	if err := checkConnections(ctx, store, api, 30 * time.Minute, now); err != nil {
+		t.Fatal(err)
+	}
+	// A gone connection is deleted from the store; an idle one is closed, and
+	// $disconnect deletes it then.
+	if _, err := store.GetConnection(ctx, "gone"); !errors.Is(err, chat.ErrNotFound) {
+		t.Errorf("gone connection: %v, want ErrNotFound", err)
+	}
+	if !api.IsClosed("idle") {
+		t.Error("idle connection was not closed")
+	}
+	for _, id := range []string{"active", "unknown", "failing"} {
+		if api.IsClosed(id) {
+			t.Errorf("%s connection was closed", id)
+		}
+		if _, err := store.GetConnection(ctx, id); err != nil {
+			t.Errorf("%s connection: %v, want it kept", id, err)
+		}
+	}
+}
+
+func TestCheckConnectionsWithoutIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := chat.NewMemoryStore(0)
+	if err := store.PutConnection(ctx, chat.Connection{ConnectionId: "idle", Room: chat.DefaultRoom}); err != nil {
+		t.Fatal(err)
+	}
+	api := &chattest.API{LastActive: map[string]time.Time{"idle": now.Add(-24 * time.Hour)}}
+	if err := checkConnections(ctx, store, api, 0, now); err != nil {
+		t.Fatal(err)
+	}
+	if api.IsClosed("idle") {
+		t.Error("idle connection was closed without an idle timeout")
+	}
+}
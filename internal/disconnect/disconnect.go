@@ -0,0 +1,46 @@
+package disconnect
+
+import (
+	"fmt"
+	"log"
+	"context"
+	"net/http"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+type ErrorResponse struct {
+	Message  string `json:"message"`
+}
+
+type Response events.APIGatewayProxyResponse
+
+func HandleRequest(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (Response, error) {
+	store := chat.DefaultStore(ctx)
+	// The connection is read first to tell its room who left.
+	connection, getErr := store.GetConnection(ctx, request.RequestContext.ConnectionID)
+	err := store.DeleteConnection(ctx, request.RequestContext.ConnectionID)
+	log.Print(request.RequestContext.Identity.SourceIP)
+	if err != nil {
+		jsonBytes, _ := json.Marshal(ErrorResponse{Message: fmt.Sprint(err)})
+		return Response{
+			StatusCode: http.StatusInternalServerError,
+			Body: string(jsonBytes),
+		}, nil
+	}
+	if getErr == nil {
+		cfg := chat.GetConfig(ctx)
+		apigatewayClient := chat.DefaultConnectionAPI(cfg, request.RequestContext)
+		if err = chat.Announce(ctx, cfg, store, apigatewayClient, request.RequestContext, chat.EventLeft, connection); err != nil {
+			log.Print(err)
+		}
+	} else {
+		log.Print(getErr)
+	}
+	return Response {
+		StatusCode: http.StatusOK,
+		Body: "",
+	}, nil
+}
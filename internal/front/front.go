@@ -0,0 +1,152 @@
+package front
+
+import (
+	"io"
+	"os"
+	"log"
+	"sort"
+	"time"
+	"bytes"
+	"context"
+	"strconv"
+	"net/url"
+	"net/http"
+	"html/template"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/templates"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+type TemplateData struct {
+	Title   string
+	Room    string
+	Url     string
+	Max     int
+	Bucket  string
+	Before  int
+	Older   int
+	Seq     int
+	LogList []LogData
+}
+
+type LogData struct {
+	Seq       int            `json:"seq"`
+	Text      string         `json:"text"`
+	ImageUrl  string         `json:"imageurl"`
+	Name      string         `json:"name"`
+	Color     string         `json:"color"`
+	Created   int64          `json:"created"`
+	Time      string         `json:"time"`
+	Edited    bool           `json:"edited"`
+	Deleted   bool           `json:"deleted"`
+	Reactions []ReactionData `json:"reactions"`
+	ParentId  int            `json:"parentId"`
+	Replies   int            `json:"replies"`
+}
+
+type ReactionData struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+type Response events.APIGatewayProxyResponse
+
+const title string = "Simple Chat"
+
+func HandleRequest(ctx context.Context, request events.APIGatewayV2HTTPRequest) (Response, error) {
+	var dat TemplateData
+	fnc := template.FuncMap{
+		"safehtml": func(text string) template.HTML { return template.HTML(text) },
+	}
+	buf := new(bytes.Buffer)
+	fw := io.Writer(buf)
+	tmp := template.Must(template.New("tmp").Funcs(fnc).ParseFS(templates.FS, "index.html", "view.html", "header.html"))
+	dat.Title = title
+	dat.Room = chat.DefaultRoom
+	dat.Url = os.Getenv("WEBSOCKET_URL")
+	if name, ok := request.PathParameters["name"]; ok {
+		if !chat.ValidRoom(name) {
+			return Response{
+				StatusCode: http.StatusNotFound,
+				Body:       http.StatusText(http.StatusNotFound),
+			}, nil
+		}
+		dat.Room = name
+		dat.Url += "?room=" + url.QueryEscape(name)
+	}
+	dat.Max, _ = strconv.Atoi(os.Getenv("LIMIT_MESSAGE_COUNT"))
+	dat.Bucket = os.Getenv("BUCKET_NAME")
+	dat.Before, _ = strconv.Atoi(request.QueryStringParameters["before"])
+	// One extra message is read to know whether an older page exists.
+	query := chat.MessageQuery{Room: dat.Room, Before: dat.Before}
+	if dat.Max > 0 {
+		query.Limit = dat.Max + 1
+	}
+	messageList, err := chat.DefaultStore(ctx).ListMessages(ctx, query)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if query.Limit > 0 && len(messageList) > dat.Max {
+		messageList = messageList[1:]
+		dat.Older = messageList[0].Seq
+	}
+	if len(messageList) > 0 {
+		dat.Seq = messageList[len(messageList) - 1].Seq
+	}
+	dat.LogList = getLogList(messageList)
+	if err = tmp.ExecuteTemplate(fw, "base", dat); err != nil {
+		log.Fatal(err)
+	}
+	res := Response{
+		StatusCode:      http.StatusOK,
+		IsBase64Encoded: false,
+		Body:            string(buf.Bytes()),
+		Headers: map[string]string{
+			"Content-Type": "text/html",
+		},
+	}
+	return res, nil
+}
+
+func getLogList(messageList []chat.MessageData) []LogData {
+	var logList []LogData
+	for _, i := range messageList {
+		text := ""
+		imageUrl := ""
+		if i.IsImage() {
+			imageUrl = i.Data
+		} else {
+			text = i.Data
+		}
+		logList = append(logList, LogData{
+			Seq: i.Seq,
+			Text: text,
+			ImageUrl: imageUrl,
+			Name: i.Name,
+			Color: i.Color,
+			Created: i.Created,
+			Time: chat.TimestampTime(i.Created).UTC().Format(time.RFC3339),
+			Edited: i.Edited > 0,
+			Deleted: i.Deleted,
+			Reactions: getReactionList(i),
+			ParentId: i.ParentId,
+			Replies: i.Replies,
+		})
+	}
+	return logList
+}
+
+func getReactionList(item chat.MessageData) []ReactionData {
+	var reactionList []ReactionData
+	for emoji, count := range item.ReactionCounts() {
+		reactionList = append(reactionList, ReactionData{
+			Emoji: emoji,
+			Count: count,
+		})
+	}
+	sort.Slice(reactionList, func(i, j int) bool {
+		return reactionList[i].Emoji < reactionList[j].Emoji
+	})
+	return reactionList
+}
@@ -0,0 +1,101 @@
+package send
+
+import (
+	"log"
+	"html"
+	"time"
+	"errors"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// directMessage saves a message to another participant in the conversation of
+// the two and posts it only to their connections, not to the room.
+func directMessage(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	store := chat.DefaultStore(ctx)
+	apigatewayClient := chat.DefaultConnectionAPI(cfg, request.RequestContext)
+	envelope, err := decodeRequest(request, chat.TypeDm)
+	if err != nil {
+		return err
+	}
+	var p chat.DirectPayload
+	if err = envelope.Decode(&p); err != nil {
+		log.Print(err)
+		return chat.NewFrameError(chat.CodeInvalidPayload, err)
+	}
+	if len(p.Text) == 0 {
+		return chat.NewFrameError(chat.CodeInvalidPayload, errors.New("text is empty"))
+	}
+	connection, err := getSender(ctx, store, request)
+	if err != nil {
+		return err
+	}
+	from := connection.Key()
+	if p.To == from {
+		return chat.NewFrameError(chat.CodeInvalidPayload, errors.New("can not send to yourself"))
+	}
+	targetList, err := chat.ListKeyConnections(ctx, store, p.To)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if len(targetList) == 0 {
+		return chat.NewFrameError(chat.CodeNotFound, errors.New("user is not connected"))
+	}
+	room := chat.ConversationKey(from, p.To)
+
+	// A client resends a frame whose ack did not arrive. The copy is looked up in
+	// the conversation of the sender, and only acked again if the sender saved it.
+	// A guest that reconnected is a new sender, so its copy starts a new conversation.
+	if envelope.Id != "" {
+		saved, err := store.FindClientMessage(ctx, room, envelope.Id)
+		if err == nil && saved.OwnedBy(connection) {
+			postAck(ctx, apigatewayClient, request, envelope.Id, saved)
+			return nil
+		} else if err != nil && !errors.Is(err, chat.ErrNotFound) {
+			log.Print(err)
+		}
+	}
+	saved, err := store.SaveMessage(ctx, chat.MessageData{
+		Room: room,
+		Type: chat.TypeDm,
+		Data: html.EscapeString(p.Text),
+		Created: chat.Timestamp(time.Now()),
+		ConnectionId: connection.ConnectionId,
+		UserId: connection.UserId,
+		ClientId: envelope.Id,
+		Name: connection.Name,
+		Color: connection.Color,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if envelope.Id != "" {
+		postAck(ctx, apigatewayClient, request, envelope.Id, saved)
+	}
+	jsonBytes, err := chat.NewEnvelope(chat.TypeDm, envelope.Id, chat.DirectPayload{
+		Seq:   saved.Seq,
+		To:    p.To,
+		From:  from,
+		Name:  saved.Name,
+		Color: saved.Color,
+		Text:  saved.Data,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	// The other connections of the sender get a copy as well.
+	senderList, err := chat.ListKeyConnections(ctx, store, from)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	chat.PostToConnections(ctx, store, apigatewayClient, append(targetList, senderList...), jsonBytes, connection.ConnectionId)
+	return nil
+}
@@ -0,0 +1,105 @@
+package send
+
+import (
+	"context"
+	"testing"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat/chattest"
+)
+
+// count returns how many envelopes of typ api posted to connectionId.
+func count(t *testing.T, api *chattest.API, connectionId string, typ string) int {
+	n := 0
+	for _, data := range api.Posts(connectionId) {
+		envelope, err := chat.DecodeEnvelope([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func dmRequest(connectionId string, id string, to string) events.APIGatewayWebsocketProxyRequest {
+	payload, _ := json.Marshal(chat.DirectPayload{To: to, Text: "hello"})
+	body, _ := json.Marshal(chat.Envelope{Action: "dm", V: chat.EnvelopeVersion, Type: chat.TypeDm, Id: id, Payload: payload})
+	var request events.APIGatewayWebsocketProxyRequest
+	request.RequestContext.ConnectionID = connectionId
+	request.RequestContext.RouteKey = "dm"
+	request.Body = string(body)
+	return request
+}
+
+func setup(t *testing.T, connectionList ...chat.Connection)(*chat.MemoryStore, *chattest.API) {
+	t.Setenv("REGION", "us-east-1")
+	store := chat.NewMemoryStore(0)
+	api := &chattest.API{}
+	chat.SetDefaultStore(store)
+	chat.SetDefaultConnectionAPI(api)
+	t.Cleanup(func() {
+		chat.SetDefaultStore(nil)
+		chat.SetDefaultConnectionAPI(nil)
+	})
+	for _, c := range connectionList {
+		if err := store.PutConnection(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, api
+}
+
+// A signed-in user resends the direct message whose ack it did not get from
+// another connection, which is acked again instead of being saved twice.
+func TestDirectMessageResent(t *testing.T) {
+	ctx := context.Background()
+	store, api := setup(t,
+		chat.Connection{ConnectionId: "a1", UserId: "ann", Name: "Ann", Room: chat.DefaultRoom},
+		chat.Connection{ConnectionId: "a2", UserId: "ann", Name: "Ann", Room: chat.DefaultRoom},
+		chat.Connection{ConnectionId: "bob", Name: "Bob", Room: chat.DefaultRoom},
+	)
+	for _, connectionId := range []string{"a1", "a1", "a2"} {
+		if _, err := HandleRequest(ctx, dmRequest(connectionId, "d1", "connection:bob")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := count(t, api, "bob", chat.TypeDm); n != 1 {
+		t.Errorf("bob got %d direct messages, want 1", n)
+	}
+	if n := count(t, api, "a2", chat.TypeAck); n != 1 {
+		t.Errorf("the copy from the other connection got %d acks, want 1", n)
+	}
+	saved, err := store.FindClientMessage(ctx, chat.ConversationKey("user:ann", "connection:bob"), "d1")
+	if err != nil || saved.ConnectionId != "a1" {
+		t.Errorf("FindClientMessage = %+v, %v, want the message from the first connection", saved, err)
+	}
+}
+
+// Another guest with the same name and the same client id is not acked with the
+// message of the first one.
+func TestDirectMessageOfAnotherGuest(t *testing.T) {
+	ctx := context.Background()
+	store, api := setup(t,
+		chat.Connection{ConnectionId: "ann", Name: "Ann", Room: chat.DefaultRoom},
+		chat.Connection{ConnectionId: "other", Name: "Ann", Room: chat.DefaultRoom},
+		chat.Connection{ConnectionId: "bob", Name: "Bob", Room: chat.DefaultRoom},
+	)
+	for _, connectionId := range []string{"ann", "other"} {
+		if _, err := HandleRequest(ctx, dmRequest(connectionId, "d1", "connection:bob")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := count(t, api, "bob", chat.TypeDm); n != 2 {
+		t.Errorf("bob got %d direct messages, want 2", n)
+	}
+	saved, err := store.FindClientMessage(ctx, chat.ConversationKey("connection:other", "connection:bob"), "d1")
+	if err != nil || saved.ConnectionId != "other" {
+		t.Errorf("FindClientMessage = %+v, %v, want the message of the other guest", saved, err)
+	}
+}
@@ -0,0 +1,135 @@
+package send
+
+import (
+	"log"
+	"html"
+	"time"
+	"errors"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// editMessage replaces the text of a message sent by the sender and tells the room.
+func editMessage(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	store := chat.DefaultStore(ctx)
+	envelope, err := decodeRequest(request, chat.TypeEdit)
+	if err != nil {
+		return err
+	}
+	var p chat.EditPayload
+	if err = envelope.Decode(&p); err != nil {
+		log.Print(err)
+		return chat.NewFrameError(chat.CodeInvalidPayload, err)
+	}
+	if len(p.Text) == 0 {
+		return chat.NewFrameError(chat.CodeInvalidPayload, errors.New("text is empty"))
+	}
+	item, err := getOwnMessage(ctx, store, request, p.Seq)
+	if err != nil {
+		return err
+	}
+	if item.IsImage() {
+		return chat.NewFrameError(chat.CodeInvalidPayload, errors.New("images can not be edited"))
+	}
+	item.Data = html.EscapeString(p.Text)
+	item.Edited = chat.Timestamp(time.Now())
+	if err = updateMessage(ctx, store, item); err != nil {
+		return err
+	}
+	jsonBytes, err := chat.NewEnvelope(chat.TypeEdited, envelope.Id, chat.EditPayload{
+		Seq:  item.Seq,
+		Text: item.Data,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return chat.BroadcastRoom(ctx, cfg, store, chat.DefaultConnectionAPI(cfg, request.RequestContext), request.RequestContext, item.Room, jsonBytes, "")
+}
+
+// deleteMessage leaves a tombstone in place of a message sent by the sender and tells the room.
+// An uploaded image stays in the bucket.
+func deleteMessage(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	store := chat.DefaultStore(ctx)
+	envelope, err := decodeRequest(request, chat.TypeDelete)
+	if err != nil {
+		return err
+	}
+	var p chat.DeletePayload
+	if err = envelope.Decode(&p); err != nil {
+		log.Print(err)
+		return chat.NewFrameError(chat.CodeInvalidPayload, err)
+	}
+	item, err := getOwnMessage(ctx, store, request, p.Seq)
+	if err != nil {
+		return err
+	}
+	item.Data = ""
+	item.Deleted = true
+	if err = updateMessage(ctx, store, item); err != nil {
+		return err
+	}
+	jsonBytes, err := chat.NewEnvelope(chat.TypeDeleted, envelope.Id, chat.DeletePayload{
+		Seq: item.Seq,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	apigatewayClient := chat.DefaultConnectionAPI(cfg, request.RequestContext)
+	if err = chat.BroadcastRoom(ctx, cfg, store, apigatewayClient, request.RequestContext, item.Room, jsonBytes, ""); err != nil {
+		return err
+	}
+	// A deleted reply is no longer counted on its parent.
+	if item.ParentId > 0 {
+		return broadcastReplies(ctx, cfg, store, apigatewayClient, request, item.Room, item.ParentId, -1)
+	}
+	return nil
+}
+
+// decodeRequest decodes the body of request, which must be an envelope of typ.
+func decodeRequest(request events.APIGatewayWebsocketProxyRequest, typ string)(chat.Envelope, error) {
+	envelope, err := chat.DecodeEnvelope([]byte(request.Body))
+	if err != nil {
+		log.Print(err)
+		return envelope, chat.NewFrameError(chat.CodeInvalidPayload, err)
+	}
+	if envelope.Type != typ {
+		return envelope, chat.NewFrameError(chat.CodeInvalidPayload, errors.New("unsupported envelope type " + envelope.Type))
+	}
+	return envelope, nil
+}
+
+// getOwnMessage returns message seq of the sender's room if the sender owns it and it is not deleted.
+func getOwnMessage(ctx context.Context, store chat.Store, request events.APIGatewayWebsocketProxyRequest, seq int)(chat.MessageData, error) {
+	connection, err := getSender(ctx, store, request)
+	if err != nil {
+		return chat.MessageData{}, err
+	}
+	item, err := store.GetMessage(ctx, connection.Room, seq)
+	if errors.Is(err, chat.ErrNotFound) || (err == nil && item.Deleted) {
+		return item, chat.NewFrameError(chat.CodeNotFound, errors.New("message is not found"))
+	} else if err != nil {
+		log.Print(err)
+		return item, err
+	}
+	if !item.OwnedBy(connection) {
+		return item, chat.NewFrameError(chat.CodeForbidden, errors.New("message is not yours"))
+	}
+	return item, nil
+}
+
+func updateMessage(ctx context.Context, store chat.Store, item chat.MessageData) error {
+	err := store.UpdateMessage(ctx, item)
+	if errors.Is(err, chat.ErrNotFound) {
+		return chat.NewFrameError(chat.CodeNotFound, errors.New("message is not found"))
+	} else if err != nil {
+		log.Print(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package send
+
+import (
+	"log"
+	"time"
+	"errors"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// limitRate takes a token from the buckets of the connection and of its source IP,
+// and returns a rate_limited error if either is empty. Typing events have their own
+// throttle per connection, so they are only counted for the source IP, which still
+// bounds the writes a client can cause by opening more connections.
+func limitRate(ctx context.Context, store chat.RateLimitStore, request events.APIGatewayWebsocketProxyRequest) error {
+	now := time.Now()
+	type bucket struct {
+		key   string
+		limit chat.RateLimit
+	}
+	var buckets []bucket
+	if request.RequestContext.RouteKey != "typing" {
+		buckets = append(buckets, bucket{"connection:" + request.RequestContext.ConnectionID, chat.RateLimitFromEnv("LIMIT_SEND_RATE", "LIMIT_SEND_BURST")})
+	}
+	buckets = append(buckets, bucket{"ip:" + request.RequestContext.Identity.SourceIP, chat.RateLimitFromEnv("LIMIT_IP_SEND_RATE", "LIMIT_IP_SEND_BURST")})
+	for _, b := range buckets {
+		if !b.limit.Enabled() {
+			continue
+		}
+		ok, err := store.TakeToken(ctx, b.key, b.limit, now)
+		if err != nil {
+			// The chat keeps working when the limiter can not be reached.
+			log.Print(err)
+			continue
+		}
+		if !ok {
+			return chat.NewFrameError(chat.CodeRateLimited, errors.New("too many frames, slow down"))
+		}
+	}
+	return nil
+}
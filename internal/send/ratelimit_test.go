@@ -0,0 +1,44 @@
+package send
+
+import (
+	"errors"
+	"context"
+	"testing"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+func rateRequest(connectionId string, routeKey string) events.APIGatewayWebsocketProxyRequest {
+	var request events.APIGatewayWebsocketProxyRequest
+	request.RequestContext.ConnectionID = connectionId
+	request.RequestContext.RouteKey = routeKey
+	request.RequestContext.Identity.SourceIP = "192.0.2.1"
+	return request
+}
+
+func isRateLimited(err error) bool {
+	var frameErr *chat.FrameError
+	return errors.As(err, &frameErr) && frameErr.Code == chat.CodeRateLimited
+}
+
+func TestLimitRateCountsTypingForTheIp(t *testing.T) {
+	t.Setenv("LIMIT_SEND_RATE", "1")
+	t.Setenv("LIMIT_SEND_BURST", "1")
+	t.Setenv("LIMIT_IP_SEND_RATE", "1")
+	t.Setenv("LIMIT_IP_SEND_BURST", "2")
+	ctx := context.Background()
+	store := chat.NewMemoryStore(0)
+
+	// Typing does not use the bucket of the connection...
+	if err := limitRate(ctx, store, rateRequest("c1", "typing")); err != nil {
+		t.Fatal(err)
+	}
+	if err := limitRate(ctx, store, rateRequest("c1", "sendmessage")); err != nil {
+		t.Fatalf("message after typing: %v", err)
+	}
+	// ...but the IP bucket is empty now, even for typing on another connection.
+	if err := limitRate(ctx, store, rateRequest("c2", "typing")); !isRateLimited(err) {
+		t.Errorf("typing over the IP limit = %v, want rate_limited", err)
+	}
+}
@@ -0,0 +1,60 @@
+package send
+
+import (
+	"log"
+	"errors"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// reactMessage adds or removes the sender's reaction to a message in its room
+// and tells the room the new count of that emoji.
+func reactMessage(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	store := chat.DefaultStore(ctx)
+	envelope, err := decodeRequest(request, chat.TypeReact)
+	if err != nil {
+		return err
+	}
+	var p chat.ReactionPayload
+	if err = envelope.Decode(&p); err != nil {
+		log.Print(err)
+		return chat.NewFrameError(chat.CodeInvalidPayload, err)
+	}
+	if !chat.ValidEmoji(p.Emoji) {
+		return chat.NewFrameError(chat.CodeInvalidPayload, errors.New("invalid emoji"))
+	}
+	connection, err := getSender(ctx, store, request)
+	if err != nil {
+		return err
+	}
+	item, err := store.GetMessage(ctx, connection.Room, p.Seq)
+	if errors.Is(err, chat.ErrNotFound) || (err == nil && item.Deleted) {
+		return chat.NewFrameError(chat.CodeNotFound, errors.New("message is not found"))
+	} else if err != nil {
+		log.Print(err)
+		return err
+	}
+	count, err := store.UpdateReaction(ctx, connection.Room, p.Seq, p.Emoji, connection.Key(), !p.Remove)
+	if errors.Is(err, chat.ErrNotFound) {
+		return chat.NewFrameError(chat.CodeNotFound, errors.New("message is not found"))
+	} else if err != nil {
+		log.Print(err)
+		return err
+	}
+	jsonBytes, err := chat.NewEnvelope(chat.TypeReaction, envelope.Id, chat.ReactionPayload{
+		Seq:    p.Seq,
+		Emoji:  p.Emoji,
+		Remove: p.Remove,
+		Name:   connection.Name,
+		Count:  count,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return chat.BroadcastRoom(ctx, cfg, store, chat.DefaultConnectionAPI(cfg, request.RequestContext), request.RequestContext, connection.Room, jsonBytes, "")
+}
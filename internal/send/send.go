@@ -0,0 +1,290 @@
+package send
+
+import (
+	"os"
+	"fmt"
+	"log"
+	"html"
+	"time"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"context"
+	"net/http"
+	"encoding/json"
+	"path/filepath"
+	"encoding/base64"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewaymanagementapi"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+type ErrorResponse struct {
+	Message  string `json:"message"`
+}
+
+type Response events.APIGatewayProxyResponse
+
+func HandleRequest(ctx context.Context, request events.APIGatewayWebsocketProxyRequest) (Response, error) {
+	cfg := chat.GetConfig(ctx)
+	err := limitRate(ctx, chat.DefaultStore(ctx), request)
+	if err == nil {
+		err = route(ctx, cfg, request)
+	}
+	log.Print(request.RequestContext.Identity.SourceIP)
+	if err != nil {
+		log.Print(err)
+		postError(ctx, cfg, request, err)
+		var jsonBytes []byte
+		jsonBytes, _ = json.Marshal(ErrorResponse{Message: fmt.Sprint(err)})
+		return Response{
+			StatusCode: http.StatusInternalServerError,
+			Body: string(jsonBytes),
+		}, nil
+	}
+	return Response {
+		StatusCode: http.StatusOK,
+		Body: "",
+	}, nil
+}
+
+// route handles the frame by the route API Gateway selected for it.
+func route(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	var err error
+	switch request.RequestContext.RouteKey {
+	case "sync":
+		err = syncMessages(ctx, cfg, request)
+	case "edit":
+		err = editMessage(ctx, cfg, request)
+	case "delete":
+		err = deleteMessage(ctx, cfg, request)
+	case "react":
+		err = reactMessage(ctx, cfg, request)
+	case "thread":
+		err = threadMessages(ctx, cfg, request)
+	case "typing":
+		err = typing(ctx, cfg, request)
+	case "who":
+		err = who(ctx, cfg, request)
+	case "dm":
+		err = directMessage(ctx, cfg, request)
+	default:
+		err = sendMessage(ctx, cfg, request)
+	}
+	return err
+}
+
+// postError tells the sender why its frame failed. A frame with an id gets a nack
+// with that id, so the client can tell which one it was; others get an error frame.
+func postError(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest, err error) {
+	var frame struct {
+		Id string `json:"id"`
+	}
+	_ = json.Unmarshal([]byte(request.Body), &frame)
+	frameType := chat.TypeError
+	if frame.Id != "" {
+		frameType = chat.TypeNack
+	}
+	jsonBytes, err := chat.NewEnvelope(frameType, frame.Id, chat.ErrorPayloadOf(err))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	_ = postToSender(ctx, chat.DefaultConnectionAPI(cfg, request.RequestContext), request, jsonBytes)
+}
+
+// postAck tells the sender that the frame with id was saved as item.
+func postAck(ctx context.Context, apigatewayClient chat.ConnectionAPI, request events.APIGatewayWebsocketProxyRequest, id string, item chat.MessageData) {
+	jsonBytes, err := chat.NewEnvelope(chat.TypeAck, id, chat.AckPayload{
+		Seq: item.Seq,
+		Ts:  item.Created,
+	})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	_ = postToSender(ctx, apigatewayClient, request, jsonBytes)
+}
+
+func postToSender(ctx context.Context, apigatewayClient chat.ConnectionAPI, request events.APIGatewayWebsocketProxyRequest, data []byte) error {
+	connectionId := request.RequestContext.ConnectionID
+	_, err := apigatewayClient.PostToConnection(ctx, &apigatewaymanagementapi.PostToConnectionInput{
+		Data:         data,
+		ConnectionId: &connectionId,
+	})
+	if err != nil {
+		log.Print(err)
+	}
+	return err
+}
+
+// getSender returns the connection of the sender with its room set.
+func getSender(ctx context.Context, store chat.Store, request events.APIGatewayWebsocketProxyRequest)(chat.Connection, error) {
+	connection, err := store.GetConnection(ctx, request.RequestContext.ConnectionID)
+	if errors.Is(err, chat.ErrNotFound) {
+		return connection, chat.NewFrameError(chat.CodeNotFound, errors.New("connection is not registered"))
+	} else if err != nil {
+		log.Print(err)
+		return connection, err
+	}
+	if connection.Room == "" {
+		connection.Room = chat.DefaultRoom
+	}
+	return connection, nil
+}
+
+func uploadImage(ctx context.Context, cfg aws.Config, filename string, filedata string)(string, error) {
+	t := time.Now()
+	b64data := filedata[strings.IndexByte(filedata, ',')+1:]
+	data, err := base64.StdEncoding.DecodeString(b64data)
+	if err != nil {
+		log.Print(err)
+		return "", chat.NewFrameError(chat.CodeInvalidPayload, err)
+	}
+	extension := filepath.Ext(filename)
+	var contentType string
+
+	switch extension {
+	case ".jpg":
+		contentType = "image/jpeg"
+	case ".jpeg":
+		contentType = "image/jpeg"
+	case ".gif":
+		contentType = "image/gif"
+	case ".png":
+		contentType = "image/png"
+	default:
+		return "", chat.NewFrameError(chat.CodeUnsupportedMedia, errors.New("this extension is invalid"))
+	}
+	filename_ := string([]rune(filename)[:(len(filename) - len(extension))]) + strconv.FormatInt(chat.Timestamp(t), 10) + extension
+	uploader := s3manager.NewUploader(s3.NewFromConfig(cfg))
+	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+		ACL: s3types.ObjectCannedACLPublicRead,
+		Bucket: aws.String(os.Getenv("BUCKET_NAME")),
+		Key: aws.String(filename_),
+		Body: bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		log.Print(err)
+		return "", err
+	}
+	return "https://" + os.Getenv("BUCKET_NAME") + ".s3-" + os.Getenv("REGION") + ".amazonaws.com/" + filename_, nil
+}
+
+func sendMessage(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	store := chat.DefaultStore(ctx)
+	apigatewayClient := chat.DefaultConnectionAPI(cfg, request.RequestContext)
+	envelope, err := chat.DecodeEnvelope([]byte(request.Body))
+	if err != nil {
+		log.Print(err)
+		return chat.NewFrameError(chat.CodeInvalidPayload, err)
+	}
+
+	var message string
+	var image chat.ImagePayload
+	var parentId int
+	isText := true
+	switch envelope.Type {
+	case chat.TypeMessage:
+		var p chat.MessagePayload
+		if err = envelope.Decode(&p); err != nil {
+			log.Print(err)
+			return chat.NewFrameError(chat.CodeInvalidPayload, err)
+		}
+		if len(p.Text) == 0 {
+			return chat.NewFrameError(chat.CodeInvalidPayload, errors.New("text is empty"))
+		}
+		message = html.EscapeString(p.Text)
+		parentId = p.ParentId
+	case chat.TypeImage:
+		if err = envelope.Decode(&image); err != nil {
+			log.Print(err)
+			return chat.NewFrameError(chat.CodeInvalidPayload, err)
+		}
+		parentId = image.ParentId
+		isText = false
+	default:
+		return chat.NewFrameError(chat.CodeInvalidPayload, errors.New("unsupported envelope type " + envelope.Type))
+	}
+	connection, err := getSender(ctx, store, request)
+	if err != nil {
+		return err
+	}
+	color := connection.Color
+	room := connection.Room
+
+	// A client resends a frame when the ack does not arrive. The copy is acked
+	// again with the saved message instead of being saved twice, if the sender
+	// saved it; names are not unique, so the owner is compared instead.
+	if envelope.Id != "" {
+		saved, err := store.FindClientMessage(ctx, room, envelope.Id)
+		if err == nil && saved.OwnedBy(connection) {
+			postAck(ctx, apigatewayClient, request, envelope.Id, saved)
+			return nil
+		} else if err != nil && !errors.Is(err, chat.ErrNotFound) {
+			log.Print(err)
+		}
+	}
+	if parentId > 0 {
+		parentId, err = getThread(ctx, store, room, parentId)
+		if err != nil {
+			return err
+		}
+	}
+	if !isText {
+		message, err = uploadImage(ctx, cfg, image.Filename, image.Data)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+	}
+
+	messageType := chat.TypeMessage
+	if !isText {
+		messageType = chat.TypeImage
+	}
+	saved, err := store.SaveMessage(ctx, chat.MessageData{
+		Room: room,
+		Type: messageType,
+		Data: message,
+		Created: chat.Timestamp(time.Now()),
+		ConnectionId: request.RequestContext.ConnectionID,
+		UserId: connection.UserId,
+		ClientId: envelope.Id,
+		Name: connection.Name,
+		Color: color,
+		ParentId: parentId,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if envelope.Id != "" {
+		postAck(ctx, apigatewayClient, request, envelope.Id, saved)
+	}
+	jsonBytes, err := chat.MessageEnvelope(envelope.Id, saved)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	// The sender already shows its own text.
+	skip := ""
+	if isText {
+		skip = request.RequestContext.ConnectionID
+	}
+	if err = chat.BroadcastRoom(ctx, cfg, store, apigatewayClient, request.RequestContext, room, jsonBytes, skip); err != nil {
+		return err
+	}
+	if parentId > 0 {
+		return broadcastReplies(ctx, cfg, store, apigatewayClient, request, room, parentId, 1)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package send
+
+import (
+	"context"
+	"testing"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+func frameRequest(connectionId string, routeKey string, typ string, id string, payload interface{}) events.APIGatewayWebsocketProxyRequest {
+	b, _ := json.Marshal(payload)
+	body, _ := json.Marshal(chat.Envelope{Action: routeKey, V: chat.EnvelopeVersion, Type: typ, Id: id, Payload: b})
+	var request events.APIGatewayWebsocketProxyRequest
+	request.RequestContext.ConnectionID = connectionId
+	request.RequestContext.RouteKey = routeKey
+	request.Body = string(body)
+	return request
+}
+
+func messageRequest(connectionId string, id string) events.APIGatewayWebsocketProxyRequest {
+	return frameRequest(connectionId, "send", chat.TypeMessage, id, chat.MessagePayload{Text: "hello"})
+}
+
+// A resent message is saved once, but a message of another guest with the same
+// name and the same client id is its own.
+func TestSendMessageResent(t *testing.T) {
+	ctx := context.Background()
+	store, api := setup(t,
+		chat.Connection{ConnectionId: "ann", Name: "Ann", Room: chat.DefaultRoom},
+		chat.Connection{ConnectionId: "other", Name: "Ann", Room: chat.DefaultRoom},
+		chat.Connection{ConnectionId: "bob", Name: "Bob", Room: chat.DefaultRoom},
+	)
+	for _, connectionId := range []string{"ann", "ann", "other"} {
+		if _, err := HandleRequest(ctx, messageRequest(connectionId, "m1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := count(t, api, "bob", chat.TypeMessage); n != 2 {
+		t.Errorf("bob got %d messages, want 2", n)
+	}
+	if n := count(t, api, "ann", chat.TypeAck); n != 2 {
+		t.Errorf("ann got %d acks, want 2", n)
+	}
+	messageList, err := store.ListMessages(ctx, chat.MessageQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messageList) != 2 || messageList[0].ConnectionId != "ann" || messageList[1].ConnectionId != "other" {
+		t.Errorf("saved %+v, want one message of each guest", messageList)
+	}
+}
+
+// Deleting a reply counts it off its parent and tells the room.
+func TestDeleteReply(t *testing.T) {
+	ctx := context.Background()
+	store, api := setup(t,
+		chat.Connection{ConnectionId: "ann", Name: "Ann", Room: chat.DefaultRoom},
+		chat.Connection{ConnectionId: "bob", Name: "Bob", Room: chat.DefaultRoom},
+	)
+	for _, request := range []events.APIGatewayWebsocketProxyRequest{
+		frameRequest("ann", "send", chat.TypeMessage, "m1", chat.MessagePayload{Text: "parent"}),
+		frameRequest("ann", "send", chat.TypeMessage, "m2", chat.MessagePayload{Text: "reply", ParentId: 1}),
+		frameRequest("ann", "delete", chat.TypeDelete, "m3", chat.DeletePayload{Seq: 2}),
+	} {
+		if _, err := HandleRequest(ctx, request); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	parent, err := store.GetMessage(ctx, chat.DefaultRoom, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.Replies != 0 {
+		t.Errorf("parent has %d replies, want 0", parent.Replies)
+	}
+	var counts []int
+	for _, data := range api.Posts("bob") {
+		envelope, err := chat.DecodeEnvelope([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p chat.ThreadPayload
+		if envelope.Type == chat.TypeThread && envelope.Decode(&p) == nil {
+			counts = append(counts, p.Replies)
+		}
+	}
+	if len(counts) != 2 || counts[0] != 1 || counts[1] != 0 {
+		t.Errorf("bob was told reply counts %v, want [1 0]", counts)
+	}
+}
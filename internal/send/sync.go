@@ -0,0 +1,87 @@
+package send
+
+import (
+	"os"
+	"log"
+	"errors"
+	"context"
+	"strconv"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// maxSyncCount is the most messages a sync posts.
+const maxSyncCount int = 100
+
+// syncMessages posts the messages of the sender's room saved after the given
+// sequence number to the sender only, oldest first. A "synced" system frame
+// with the last sequence number follows them. At most maxSyncCount messages,
+// or LIMIT_MESSAGE_COUNT if lower, are posted; the synced frame tells whether
+// more are left, and the client asks for them with another sync.
+func syncMessages(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	store := chat.DefaultStore(ctx)
+	apigatewayClient := chat.DefaultConnectionAPI(cfg, request.RequestContext)
+	envelope, err := decodeRequest(request, chat.TypeSync)
+	if err != nil {
+		return err
+	}
+	var p chat.SyncPayload
+	if err = envelope.Decode(&p); err != nil {
+		log.Print(err)
+		return chat.NewFrameError(chat.CodeInvalidPayload, err)
+	}
+	if p.Since < 0 {
+		return chat.NewFrameError(chat.CodeInvalidPayload, errors.New("since is negative"))
+	}
+	connection, err := getSender(ctx, store, request)
+	if err != nil {
+		return err
+	}
+	limit := maxSyncCount
+	if limitCount, _ := strconv.Atoi(os.Getenv("LIMIT_MESSAGE_COUNT")); limitCount > 0 && limitCount < limit {
+		limit = limitCount
+	}
+	// One extra message is read to know whether more are left.
+	messageList, err := store.ListMessages(ctx, chat.MessageQuery{
+		Room:   connection.Room,
+		Since:  p.Since,
+		Limit:  limit + 1,
+		Oldest: true,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	more := len(messageList) > limit
+	if more {
+		messageList = messageList[:limit]
+	}
+	last := p.Since
+	for _, item := range messageList {
+		jsonBytes, err := chat.MessageEnvelope(item.ClientId, item)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+		if err = postToSender(ctx, apigatewayClient, request, jsonBytes); err != nil {
+			return err
+		}
+		if item.Seq > last {
+			last = item.Seq
+		}
+	}
+	jsonBytes, err := chat.NewEnvelope(chat.TypeSystem, envelope.Id, chat.SystemPayload{
+		Event: chat.EventSynced,
+		Room:  connection.Room,
+		Seq:   last,
+		More:  more,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return postToSender(ctx, apigatewayClient, request, jsonBytes)
+}
@@ -0,0 +1,106 @@
+package send
+
+import (
+	"log"
+	"errors"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// getThread returns the Seq of the thread to put a reply to message seq in.
+// Threads are one level deep, so a reply to a reply goes to the same thread.
+func getThread(ctx context.Context, store chat.Store, room string, seq int)(int, error) {
+	for {
+		item, err := store.GetMessage(ctx, room, seq)
+		if errors.Is(err, chat.ErrNotFound) {
+			return 0, chat.NewFrameError(chat.CodeNotFound, errors.New("parent message is not found"))
+		} else if err != nil {
+			log.Print(err)
+			return 0, err
+		}
+		if item.ParentId == 0 {
+			return item.Seq, nil
+		}
+		seq = item.ParentId
+	}
+}
+
+// broadcastReplies adds delta to the replies of the parent, 1 for a new reply and -1
+// for a deleted one, and tells the room the new count. If the parent was overwritten
+// in the meantime, the reply is an orphan and nothing is told.
+func broadcastReplies(ctx context.Context, cfg aws.Config, store chat.Store, apigatewayClient chat.ConnectionAPI, request events.APIGatewayWebsocketProxyRequest, room string, parentId int, delta int) error {
+	count, err := store.UpdateReplies(ctx, room, parentId, delta)
+	if errors.Is(err, chat.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		log.Print(err)
+		return err
+	}
+	jsonBytes, err := chat.NewEnvelope(chat.TypeThread, "", chat.ThreadPayload{
+		ParentId: parentId,
+		Replies:  count,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return chat.BroadcastRoom(ctx, cfg, store, apigatewayClient, request.RequestContext, room, jsonBytes, "")
+}
+
+// threadMessages posts the replies to a message to the sender only, oldest
+// first and with the id of the request, then a thread frame with their count.
+func threadMessages(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	store := chat.DefaultStore(ctx)
+	apigatewayClient := chat.DefaultConnectionAPI(cfg, request.RequestContext)
+	envelope, err := decodeRequest(request, chat.TypeThread)
+	if err != nil {
+		return err
+	}
+	var p chat.ThreadPayload
+	if err = envelope.Decode(&p); err != nil {
+		log.Print(err)
+		return chat.NewFrameError(chat.CodeInvalidPayload, err)
+	}
+	connection, err := getSender(ctx, store, request)
+	if err != nil {
+		return err
+	}
+	parent, err := store.GetMessage(ctx, connection.Room, p.ParentId)
+	if errors.Is(err, chat.ErrNotFound) || (err == nil && parent.ParentId != 0) {
+		return chat.NewFrameError(chat.CodeNotFound, errors.New("thread is not found"))
+	} else if err != nil {
+		log.Print(err)
+		return err
+	}
+	messageList, err := store.ListMessages(ctx, chat.MessageQuery{
+		Room:     connection.Room,
+		ParentId: parent.Seq,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	for _, item := range messageList {
+		jsonBytes, err := chat.MessageEnvelope(envelope.Id, item)
+		if err != nil {
+			log.Print(err)
+			return err
+		}
+		if err = postToSender(ctx, apigatewayClient, request, jsonBytes); err != nil {
+			return err
+		}
+	}
+	jsonBytes, err := chat.NewEnvelope(chat.TypeThread, envelope.Id, chat.ThreadPayload{
+		ParentId: parent.Seq,
+		Replies:  parent.Replies,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return postToSender(ctx, apigatewayClient, request, jsonBytes)
+}
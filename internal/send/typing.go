@@ -0,0 +1,45 @@
+package send
+
+import (
+	"log"
+	"time"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// typingInterval is the shortest interval between typing events of a connection.
+const typingInterval time.Duration = 3 * time.Second
+
+// typing tells the others in the room that the sender is typing. It is not
+// saved, and events within typingInterval of the last one are dropped silently.
+func typing(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	store := chat.DefaultStore(ctx)
+	if _, err := decodeRequest(request, chat.TypeTyping); err != nil {
+		return err
+	}
+	connection, err := getSender(ctx, store, request)
+	if err != nil {
+		return err
+	}
+	ok, err := store.MarkTyping(ctx, connection.ConnectionId, time.Now(), typingInterval)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	jsonBytes, err := chat.NewEnvelope(chat.TypeTyping, "", chat.TypingPayload{
+		Name:  connection.Name,
+		Color: connection.Color,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return chat.BroadcastRoom(ctx, cfg, store, chat.DefaultConnectionAPI(cfg, request.RequestContext), request.RequestContext, connection.Room, jsonBytes, connection.ConnectionId)
+}
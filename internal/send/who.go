@@ -0,0 +1,51 @@
+package send
+
+import (
+	"log"
+	"sort"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/tanaka-takurou/serverless-chat-page-go/internal/chat"
+)
+
+// who replies to the sender with the participants of its room, oldest first.
+func who(ctx context.Context, cfg aws.Config, request events.APIGatewayWebsocketProxyRequest) error {
+	store := chat.DefaultStore(ctx)
+	envelope, err := decodeRequest(request, chat.TypeWho)
+	if err != nil {
+		return err
+	}
+	connection, err := getSender(ctx, store, request)
+	if err != nil {
+		return err
+	}
+	connectionList, err := store.ListRoomConnections(ctx, connection.Room)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	sort.Slice(connectionList, func(i, j int) bool {
+		return connectionList[i].Created < connectionList[j].Created
+	})
+	users := []chat.PresenceUser{}
+	for _, item := range connectionList {
+		users = append(users, chat.PresenceUser{
+			Id:    item.Key(),
+			Name:  item.Name,
+			Color: item.Color,
+			Since: item.Created,
+		})
+	}
+	jsonBytes, err := chat.NewEnvelope(chat.TypePresence, envelope.Id, chat.PresencePayload{
+		Room:  connection.Room,
+		Users: users,
+	})
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	return postToSender(ctx, chat.DefaultConnectionAPI(cfg, request.RequestContext), request, jsonBytes)
+}
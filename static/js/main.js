@@ -6,12 +6,25 @@ var webSocket = null;
 
 function init() {
   $("#chat_send_message").keypress(press);
+  $("#chat_send_message").on("input", SendTyping);
+  $("#chat_messages time[data-ts]").each(function() {
+    $(this).text(FormatTime(Number($(this).attr("data-ts"))));
+  });
+  $("#chat_messages > .item[data-seq]").each(function() {
+    var item = $(this);
+    var seq = Number(item.attr("data-seq"));
+    if (!item.hasClass("deleted")) {
+      AddReactionBar(item, seq);
+    }
+    item.find(".replies").click(function() { OpenThread(seq); return false; });
+    item.find(".reply-to").click(function() { ScrollToItem(Number($(this).attr("data-parent"))); return false; });
+  });
   open();
 }
 
 function open() {
   if (webSocket == null) {
-    webSocket = new WebSocket(App.url);
+    webSocket = new WebSocket(GetWebSocketUrl());
     webSocket.onopen = onOpen;
     webSocket.onmessage = onMessage;
     webSocket.onclose = onClose;
@@ -20,24 +33,108 @@ function open() {
 }
 
 function onOpen(event) {
+  if (App.joined) {
+    // Reconnected: ask for the messages broadcast while the socket was closed.
+    webSocket.send(NewEnvelope('sync', 'sync', { since: App.lastSeq }));
+  }
+  App.joined = true;
+  Who();
   console.log('Join');
 }
 
 function onMessage(event) {
   if (event && event.data) {
-    var res = JSON.parse(event.data);
-    chat(res.data , res.color, false);
+    var res = ParseEnvelope(event.data);
+    var p = res.payload || {};
+    if (res.id && App.threads[res.id]) {
+      ShowThread(res);
+      return;
+    }
+    switch (res.type) {
+      case 'message':
+        if (Seen(p.seq) || FindItem(res.id).length > 0) {
+          break;
+        }
+        ShowState(chat(p.text, p.color, false, p.name, '', p.seq), p);
+        StopTyping(p.name);
+        break;
+      case 'image':
+        if (Seen(p.seq)) {
+          break;
+        }
+        ShowState(chat(p.url, p.color, false, p.name, '', p.seq), p);
+        break;
+      case 'edited':
+        ShowEdited(FindItemBySeq(p.seq), p.text);
+        break;
+      case 'deleted':
+        ShowDeleted(FindItemBySeq(p.seq));
+        break;
+      case 'reaction':
+        ShowReaction(FindItemBySeq(p.seq), p.emoji, p.count);
+        break;
+      case 'thread':
+        ShowReplies(FindItemBySeq(p.parentId), p.parentId, p.replies);
+        break;
+      case 'typing':
+        ShowTyping(p.name);
+        break;
+      case 'system':
+        if (p.event == 'joined' || p.event == 'left') {
+          chat(p.name + ' ' + p.event, '888', false, '');
+          StopTyping(p.name);
+          Who();
+        } else if (p.event == 'synced' && p.more) {
+          webSocket.send(NewEnvelope('sync', 'sync', { since: p.seq }));
+        } else if (p.text) {
+          chat(p.text, '888', false, '');
+        }
+        break;
+      case 'presence':
+        ShowWho(p.users || []);
+        break;
+      case 'dm':
+        if (FindItem(res.id).length > 0) {
+          break;
+        }
+        chat(p.text, p.color, false, p.name + ' (direct)').addClass('direct');
+        break;
+      case 'ack':
+        // Direct messages are numbered in their conversation, not in the room.
+        if (!FindItem(res.id).hasClass("direct")) {
+          Seen(p.seq);
+        }
+        delete App.pending[res.id];
+        MarkSent(res.id, p.seq);
+        ShowTime(FindItem(res.id), p.ts);
+        break;
+      case 'nack':
+        delete App.pending[res.id];
+        MarkFailed(res.id);
+        chat(ErrorMessages[p.code] || p.message, 'F00', false, '');
+        break;
+      case 'error':
+        chat(ErrorMessages[p.code] || p.message, 'F00', false, '');
+        break;
+    }
   }
 }
 
 function onError(event) {
-  chat("Error", 'F00', false);
+  chat("Error", 'F00', false, '');
   console.log('Error. Wait a minute please.');
 }
 
 function onClose(event) {
   console.log('onClose');
+  if (!App.joined) {
+    // The name may be invalid or already used in the room; ask again next time.
+    localStorage.removeItem('chat_name');
+  }
   webSocket = null;
+  if (App.joined) {
+    setTimeout(open, App.reconnectDelay);
+  }
 }
 
 function press(event) {
@@ -54,38 +151,46 @@ function send() {
   var message = $("#chat_send_message").val();
   console.log(message);
   if (message && webSocket) {
-    var obj = new Object();
-    obj.text = message;
-    obj.image = '';
-    obj.action = 'send';
-    var jsonString = JSON.stringify(obj);
-    webSocket.send(jsonString);
+    var payload = { text: message };
+    if (App.replyTo) {
+      payload.parentId = App.replyTo;
+    }
+    var id = SendFrame('message', payload);
     $("#chat_send_message").val("");
-    chat(message, '00F', true);
+    ShowState(chat(message, '00F', true, App.name, id), payload);
+    CancelReply();
   }
 }
 
-function chat(message, col, slf) {
-  var chats = $("#chat_messages").find("div");
-  while (chats.length >= App.maxMessage) {
-    chats = chats.first().remove();
+function chat(message, col, slf, name, id, seq) {
+  var chats = $("#chat_messages").children(".item");
+  if (App.maxMessage > 0 && chats.length >= App.maxMessage) {
+    chats.slice(0, chats.length - App.maxMessage + 1).remove();
   }
   var itemClassName = "item"
   if (slf) {
     itemClassName = itemClassName + " self";
   }
-  var msgTag;
+  if (id) {
+    itemClassName = itemClassName + " pending";
+  }
+  var msgTag = $("<div></div>", {
+    "class": "content"
+  });
+  if (name) {
+    msgTag.append($("<div></div>", {
+      "class": "header"
+    }).text(name));
+  }
   if (CheckBucketName(message)) {
     imgTag = $("<img>", {
       "src": message
     });
-    msgTag = $("<div></div>", {
-      "class": "content"
-    }).append(imgTag);
+    msgTag.append(imgTag);
   } else {
-    msgTag = $("<div></div>", {
-      "class": "content"
-    }).text(message);
+    msgTag.append($("<span></span>", {
+      "class": "text"
+    }).text(message));
   }
   var iconTag = $("<i></i>", {
     "class": "large user middle aligned icon",
@@ -94,8 +199,415 @@ function chat(message, col, slf) {
   var msgItemTag = $("<div></div>", {
     "class": itemClassName
   }).append(iconTag).append(msgTag);
+  if (id) {
+    msgItemTag.attr("data-id", id);
+  }
+  if (seq) {
+    msgItemTag.attr("data-seq", seq);
+    AddReactionBar(msgItemTag, seq);
+  }
   $("#chat_messages").append(msgItemTag);
   ScrollMessageBottom();
+  return msgItemTag;
+}
+// NewEnvelope returns the frame for the route action, see internal/chat/envelope.go.
+function NewEnvelope(action, type, payload, id) {
+  return JSON.stringify({
+    action: action,
+    v: App.envelopeVersion,
+    type: type,
+    id: id || NewId(),
+    ts: Date.now(),
+    payload: payload
+  });
+}
+// ParseEnvelope also reads the former {data, name, color} frame as a message.
+function ParseEnvelope(data) {
+  var res = JSON.parse(data);
+  if (!res.v) {
+    var type = CheckBucketName(res.data || '') ? 'image' : 'message';
+    return { v: App.envelopeVersion, type: type, ts: Date.now(), payload: { text: res.data, url: res.data, name: res.name, color: res.color } };
+  }
+  return res;
+}
+// ErrorMessages are shown for the codes of error frames, see internal/chat/errors.go.
+var ErrorMessages = {
+  invalid_payload: 'The message could not be read.',
+  unsupported_media: 'This file type is not supported.',
+  rate_limited: 'You are sending messages too fast. Wait a moment please.',
+  not_found: 'You are not connected. Reload the page please.',
+  internal_error: 'The message could not be sent.'
+};
+// SendFrame sends a frame to the route action, send by default, and resends it
+// until it is acked. The server drops the copies of a frame that was already saved.
+function SendFrame(type, payload, action) {
+  var id = NewId();
+  App.pending[id] = { frame: NewEnvelope(action || 'send', type, payload, id), tries: 0 };
+  webSocket.send(App.pending[id].frame);
+  WaitAck(id);
+  return id;
+}
+function WaitAck(id) {
+  setTimeout(function() {
+    var p = App.pending[id];
+    if (!p) {
+      return;
+    }
+    if (p.tries >= App.maxRetry) {
+      delete App.pending[id];
+      MarkFailed(id);
+      return;
+    }
+    if (webSocket && webSocket.readyState === WebSocket.OPEN) {
+      p.tries += 1;
+      webSocket.send(p.frame);
+    }
+    WaitAck(id);
+  }, App.ackTimeout);
+}
+// Seen records the sequence number of a message and reports whether it was already shown.
+function Seen(seq) {
+  if (!seq) {
+    return false;
+  }
+  if (App.seen[seq]) {
+    return true;
+  }
+  App.seen[seq] = true;
+  if (seq > App.lastSeq) {
+    App.lastSeq = seq;
+  }
+  return false;
+}
+function FindItem(id) {
+  if (!id) {
+    return $();
+  }
+  return $("#chat_messages > .item").filter(function() {
+    return $(this).attr("data-id") === id;
+  });
+}
+function FindItemBySeq(seq) {
+  return $("#chat_messages > .item").filter(function() {
+    return $(this).attr("data-seq") === String(seq);
+  });
+}
+function MarkSent(id, seq) {
+  if (!id) {
+    return;
+  }
+  var item = FindItem(id).removeClass("pending");
+  if (item.hasClass("direct")) {
+    return;
+  }
+  item.attr("data-seq", seq);
+  if (item.find(".text").length > 0) {
+    AddControls(item, seq);
+  }
+  AddReactionBar(item, seq);
+}
+// ShowTime shows in the header of item when its message was created, ts in Unix milliseconds.
+function ShowTime(item, ts) {
+  if (!ts) {
+    return;
+  }
+  var header = item.children(".content").children(".header");
+  header.children("time").remove();
+  header.append($("<time></time>", {
+    "datetime": new Date(ts).toISOString(),
+    "data-ts": ts
+  }).text(FormatTime(ts)));
+}
+// FormatTime returns ts, in Unix milliseconds, as the local time of the browser.
+function FormatTime(ts) {
+  return new Date(ts).toLocaleString();
+}
+// AddControls adds the links to edit and delete an own message.
+function AddControls(item, seq) {
+  var controls = $("<div></div>", {
+    "class": "controls"
+  });
+  controls.append($("<a></a>", {
+    "href": "#",
+    "click": function() { EditMessage(seq); return false; }
+  }).text("Edit"));
+  controls.append($("<a></a>", {
+    "href": "#",
+    "click": function() { DeleteMessage(seq); return false; }
+  }).text("Delete"));
+  item.children(".content").append(controls);
+}
+function EditMessage(seq) {
+  var text = window.prompt('Edit message', FindItemBySeq(seq).find(".text").text());
+  if (text && webSocket) {
+    webSocket.send(NewEnvelope('edit', 'edit', { seq: seq, text: text }));
+  }
+}
+function DeleteMessage(seq) {
+  if (window.confirm('Delete this message?') && webSocket) {
+    webSocket.send(NewEnvelope('delete', 'delete', { seq: seq }));
+  }
+}
+function ShowState(item, payload) {
+  if (payload.created) {
+    ShowTime(item, payload.created);
+  }
+  if (payload.parentId) {
+    item.children(".content").children(".header").after($("<a></a>", {
+      "class": "reply-to",
+      "data-parent": payload.parentId,
+      "href": "#",
+      "click": function() { ScrollToItem(payload.parentId); return false; }
+    }).text('\u21AA #' + payload.parentId));
+  }
+  if (payload.deleted) {
+    ShowDeleted(item);
+    return;
+  }
+  if (payload.replies) {
+    ShowReplies(item, payload.seq, payload.replies);
+  }
+  if (payload.edited) {
+    ShowEdited(item, payload.text);
+  }
+  $.each(payload.reactions || {}, function(emoji, count) {
+    ShowReaction(item, emoji, count);
+  });
+}
+// AddReactionBar adds the reactions of a message and the emoji to react with.
+// The labels rendered by the server are kept.
+function AddReactionBar(item, seq) {
+  var content = item.children(".content");
+  var reactions = content.children(".reactions");
+  if (reactions.length == 0) {
+    reactions = $("<div></div>", {
+      "class": "reactions"
+    }).appendTo(content);
+  }
+  reactions.children(".reaction").off("click").click(function() {
+    React(seq, $(this).attr("data-emoji"));
+    return false;
+  });
+  if (content.children(".palette").length > 0) {
+    return;
+  }
+  var palette = $("<div></div>", {
+    "class": "palette"
+  });
+  palette.append($("<a></a>", {
+    "href": "#",
+    "click": function() { SetReply(seq); return false; }
+  }).text("Reply"));
+  $.each(App.reactionPalette, function(i, emoji) {
+    palette.append($("<a></a>", {
+      "href": "#",
+      "click": function() { React(seq, emoji); return false; }
+    }).text(emoji));
+  });
+  content.append(palette);
+}
+// SendTyping tells the room that this page is typing, at most once per
+// typingInterval like the server allows.
+function SendTyping() {
+  var now = Date.now();
+  if (!webSocket || webSocket.readyState !== WebSocket.OPEN || now - App.lastTyping < App.typingInterval) {
+    return;
+  }
+  App.lastTyping = now;
+  webSocket.send(NewEnvelope('typing', 'typing', {}));
+}
+function ShowTyping(name) {
+  clearTimeout(App.typing[name]);
+  App.typing[name] = setTimeout(function() {
+    delete App.typing[name];
+    UpdateTyping();
+  }, App.typingInterval + 1000);
+  UpdateTyping();
+}
+function StopTyping(name) {
+  if (App.typing[name]) {
+    clearTimeout(App.typing[name]);
+    delete App.typing[name];
+    UpdateTyping();
+  }
+}
+function UpdateTyping() {
+  var names = Object.keys(App.typing);
+  $("#chat_typing").text(names.length > 0 ? names.join(', ') + (names.length > 1 ? ' are' : ' is') + ' typing...' : '');
+}
+// Who asks for the participants of the room, answered by a presence frame.
+function Who() {
+  if (webSocket && webSocket.readyState === WebSocket.OPEN) {
+    webSocket.send(NewEnvelope('who', 'who', {}));
+  }
+}
+function ShowWho(users) {
+  var list = $("#chat_who").empty();
+  users.forEach(function(u) {
+    var since = u.since ? new Date(u.since).toLocaleTimeString() : '';
+    var label = $('<a class="ui label"></a>').text(u.name).css('color', '#' + u.color).attr('title', since ? 'since ' + since : '');
+    if (u.id && u.name !== App.name) {
+      label.attr('href', '#').click(function() { SendDirect(u.id, u.name); return false; });
+    }
+    list.append(label);
+  });
+}
+// SendDirect sends a direct message to the participant id, seen only by the two.
+function SendDirect(to, name) {
+  var message = (window.prompt('Direct message to ' + name) || '').trim();
+  if (message && webSocket) {
+    var id = SendFrame('dm', { to: to, text: message }, 'dm');
+    chat(message, '00F', true, 'To ' + name + ' (direct)', id).addClass('direct');
+  }
+}
+function SetReply(seq) {
+  App.replyTo = seq;
+  $("#chat_reply .seq").text(seq);
+  $("#chat_reply").show();
+  $("#chat_send_message").focus();
+}
+function CancelReply() {
+  App.replyTo = 0;
+  $("#chat_reply").hide();
+}
+function ScrollToItem(seq) {
+  var item = FindItemBySeq(seq);
+  if (item.length == 0) {
+    return;
+  }
+  var target = $("#chat_messages");
+  target.scrollTop(target.scrollTop() + item.position().top - target.position().top);
+}
+function ShowReplies(item, seq, count) {
+  var content = item.children(".content");
+  var link = content.children(".replies");
+  if (link.length == 0) {
+    link = $("<a></a>", {
+      "class": "replies",
+      "href": "#",
+      "click": function() { OpenThread(seq); return false; }
+    }).appendTo(content);
+  }
+  link.text(count + ' replies');
+}
+// OpenThread asks for the replies to a message and shows them under it.
+function OpenThread(seq) {
+  if (!webSocket) {
+    return;
+  }
+  var id = NewId();
+  App.threads[id] = seq;
+  var content = FindItemBySeq(seq).children(".content");
+  content.children(".thread").remove();
+  $("<div></div>", {
+    "class": "thread"
+  }).appendTo(content);
+  webSocket.send(NewEnvelope('thread', 'thread', { parentId: seq }, id));
+}
+function ShowThread(res) {
+  var p = res.payload || {};
+  var thread = FindItemBySeq(App.threads[res.id]).children(".content").children(".thread");
+  if (res.type == 'message' || res.type == 'image') {
+    var reply = $("<div></div>", {
+      "class": "reply"
+    }).append($("<b></b>").text(p.name + ' '));
+    if (p.deleted) {
+      reply.append($("<span></span>").text("This message was deleted."));
+    } else if (res.type == 'image') {
+      reply.append($("<img>", { "src": p.url }));
+    } else {
+      reply.append($("<span></span>").text(p.text));
+    }
+    thread.append(reply);
+  } else {
+    if (res.type == 'nack' || res.type == 'error') {
+      thread.append($("<span></span>").text(ErrorMessages[p.code] || p.message));
+    }
+    delete App.threads[res.id];
+  }
+}
+// React adds the emoji to a message, or removes it if this page added it before.
+function React(seq, emoji) {
+  if (!webSocket) {
+    return;
+  }
+  var key = seq + '/' + emoji;
+  webSocket.send(NewEnvelope('react', 'react', { seq: seq, emoji: emoji, remove: !!App.reacted[key] }));
+  App.reacted[key] = !App.reacted[key];
+}
+function ShowReaction(item, emoji, count) {
+  var reactions = item.children(".content").children(".reactions");
+  var label = reactions.children(".reaction").filter(function() {
+    return $(this).attr("data-emoji") === emoji;
+  });
+  if (count <= 0) {
+    label.remove();
+    return;
+  }
+  if (label.length == 0) {
+    label = $("<a></a>", {
+      "class": "ui label reaction",
+      "data-emoji": emoji,
+      "click": function() { React(Number(item.attr("data-seq")), emoji); return false; }
+    }).text(emoji + ' ').append($("<span></span>", {
+      "class": "count"
+    })).appendTo(reactions);
+  }
+  label.children(".count").text(count);
+}
+function ShowEdited(item, text) {
+  item.find(".text").text(text);
+  if (item.find(".edited").length == 0) {
+    item.find(".text").after($("<span></span>", {
+      "class": "edited"
+    }).text("(edited)"));
+  }
+}
+function ShowDeleted(item) {
+  var content = item.addClass("deleted").children(".content");
+  content.children().not(".header").remove();
+  content.append($("<span></span>", {
+    "class": "text"
+  }).text("This message was deleted."));
+}
+function MarkFailed(id) {
+  if (!id) {
+    return;
+  }
+  FindItem(id).removeClass("pending").addClass("failed");
+}
+function NewId() {
+  if (window.crypto && crypto.randomUUID) {
+    return crypto.randomUUID();
+  }
+  App.lastId += 1;
+  return Date.now().toString(36) + '-' + Math.random().toString(36).substring(2) + '-' + App.lastId;
+}
+function GetWebSocketUrl() {
+  var params = [];
+  var token = new URLSearchParams(location.search).get('token');
+  if (token) {
+    params.push('token=' + encodeURIComponent(token));
+  }
+  var name = GetName();
+  if (name) {
+    params.push('name=' + encodeURIComponent(name));
+  }
+  if (params.length == 0) {
+    return App.url;
+  }
+  var separator = App.url.indexOf('?') < 0 ? '?' : '&';
+  return App.url + separator + params.join('&');
+}
+function GetName() {
+  if (App.name == null) {
+    App.name = localStorage.getItem('chat_name');
+  }
+  if (App.name == null) {
+    App.name = (window.prompt('Nickname (up to 20 characters, leave empty for a guest name)') || '').trim();
+    localStorage.setItem('chat_name', App.name);
+  }
+  return App.name;
 }
 function OpenModal() {
   $('.large.modal').modal('show');
@@ -129,12 +641,7 @@ function putImage() {
   const file = $('#image').prop('files')[0];
   console.log(file.name);
   if (file && App.imgdata && webSocket) {
-    var obj = new Object();
-    obj.text = file.name;
-    obj.image = App.imgdata;
-    obj.action = 'send';
-    var jsonString = JSON.stringify(obj);
-    webSocket.send(jsonString);
+    SendFrame('image', { filename: file.name, data: App.imgdata });
     $("#chat_send_message").val("");
     CloseModal();
   }
@@ -154,5 +661,5 @@ function ScrollMessageBottom() {
   var target = $("#chat_messages");
   target.scrollTop(target.get(0).scrollHeight - target.get(0).offsetHeight);
 }
-var App = { imgdata: null, url: {{ .Url }}, maxMessage: {{ .Max }}, bucketName: {{ .Bucket }} };
+var App = { imgdata: null, name: null, joined: false, envelopeVersion: 1, lastId: 0, pending: {}, ackTimeout: 5000, maxRetry: 3, reconnectDelay: 3000, reacted: {}, replyTo: 0, threads: {}, typing: {}, lastTyping: 0, typingInterval: 3000, reactionPalette: ['\u{1F44D}', '\u{2764}\u{FE0F}', '\u{1F602}', '\u{1F389}', '\u{1F62E}'], seen: {}, lastSeq: {{ .Seq }}, url: {{ .Url }}, maxMessage: {{ .Max }}, bucketName: {{ .Bucket }} };
 $(init);